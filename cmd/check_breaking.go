@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/4nte/protodist/config"
+	"github.com/4nte/protodist/git"
+	"github.com/4nte/protodist/internal/descriptor"
+	"github.com/4nte/protodist/internal/release"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var breakingCategory string
+
+var checkBreakingCmd = &cobra.Command{
+	Use:   "check-breaking",
+	Short: "Check protos for breaking changes against the previous release",
+	Long: `Check protos for breaking changes against the previous release.
+
+Descriptor set (--descriptor_set) is compared with --previous_descriptor_set, or with descriptor sets
+published along with the latest release of each Go package. Categories are ordered:
+package (generated code), wire_json (JSON encoding) and wire (binary encoding).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if descSet == "" {
+			panic("PROTODIST_DESCRIPTOR_SET must be set")
+		}
+		category, err := descriptor.ParseCategory(breakingCategory)
+		if err != nil {
+			panic(err)
+		}
+
+		current, err := descriptor.Load(descSet)
+		if err != nil {
+			panic(err)
+		}
+
+		var previous *descriptorpb.FileDescriptorSet
+		if prevDescSet != "" {
+			previous, err = descriptor.Load(prevDescSet)
+			if err != nil {
+				panic(err)
+			}
+		} else if gitRepoOwner == "" || gitHost == "" {
+			panic("PROTODIST_GIT_REPO_OWNER and PROTODIST_GIT_HOST must be set when PROTODIST_PREVIOUS_DESCRIPTOR_SET is not set")
+		}
+
+		// Ref isn't used, releases are looked up by tags
+		if gitRef == "" {
+			gitRef = "refs/heads/master"
+		}
+		gitCfg := newGitConfig()
+
+		// Releases of private repos are looked up with the same credentials as distribution uses
+		credentialsDir, err := ioutil.TempDir("", "protodist-credentials-*")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(credentialsDir)
+		if err := git.SetupCredentials(gitCfg, credentialsDir); err != nil {
+			panic(err)
+		}
+
+		var packages []string
+		if protoOutDir != "" {
+			model := descriptor.NewModel(current, descriptor.HasGeneratedCode(protoOutDir))
+			packages = release.Packages(release.Units(gitCfg, protoOutDir, model, config.Targets{}))
+		} else {
+			packages = descriptor.Packages(current)
+		}
+
+		ok, err := release.CheckBreaking(gitCfg, packages, current, previous, category)
+		if err != nil {
+			panic(fmt.Errorf("failed to check breaking changes: %s", err))
+		}
+		if !ok {
+			os.RemoveAll(credentialsDir)
+			os.Exit(1)
+		}
+		fmt.Printf("no %s breaking changes\n", category)
+	},
+}
+
+func init() {
+	checkBreakingCmd.Flags().StringVar(&breakingCategory, "category", "wire_json", "breaking change category: package, wire_json or wire")
+	rootCmd.AddCommand(checkBreakingCmd)
+}
@@ -2,8 +2,13 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/4nte/protodist/config"
 	"github.com/4nte/protodist/git"
+	"github.com/4nte/protodist/internal/build"
+	"github.com/4nte/protodist/util"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/4nte/protodist/internal/distribute"
@@ -19,15 +24,69 @@ const (
 )
 
 var (
-	gitRef       string
-	gitHost      string
-	gitRepoOwner string
-	gitToken     string
-	protoOutDir  string
-	deploy       string
-	deployDir    string
-	verbose      bool
-	dryRun       bool
+	gitRef        string
+	gitHost       string
+	gitRepoOwner  string
+	gitToken      string
+	gitProvider   string
+	gitURLTmpl    string
+	gitUsername   string
+	gitSSHKey     string
+	gitKnownHost  string
+	gitAPIURL     string
+	createRepos   bool
+	repoVisib     string
+	repoDesc      string
+	repoBranch    string
+	publish       string
+	prBase        string
+	autoMerge     bool
+	cloneDepth    int
+	cloneSparse   bool
+	cloneCache    string
+	monorepo      string
+	bump          string
+	descSet       string
+	prevDescSet   string
+	checkBreaking string
+	buildWith     string
+	protoSrcDir   string
+	protoIncludes []string
+	buildPlugins  []string
+	protoOutDir   string
+	jsScope       string
+	jsName        string
+	jsPerPackage  bool
+	jsRegistry    string
+	jsRegToken    string
+	jsCompile     bool
+	jsProtected   []string
+	cIncludePfx   string
+	cProtected    []string
+	cNanopb       string
+	cUnbounded    string
+	pyProtobuf    string
+	pyGrpcio      string
+	pyIndexDir    string
+	rustProst     string
+	rustTonic     string
+	rustRegistry  string
+	javaGroupID   string
+	javaArtifact  string
+	javaProtobuf  string
+	javaGrpc      string
+	javaKotlin    string
+	javaRepoDir   string
+	swiftProtobuf string
+	csProtobuf    string
+	csGrpc        string
+	csFeedDir     string
+	dartProtobuf  string
+	dartGrpc      string
+	deploy        string
+	deployDir     string
+	verbose       bool
+	dryRun        bool
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -62,19 +121,155 @@ var rootCmd = &cobra.Command{
 			if deployDir == "" {
 				panic("PROTODIST_DEPLOY_DIR must be set when deploy strategy is 'local'")
 			}
+			if monorepo != "" {
+				panic("PROTODIST_MONOREPO can't be used when deploy strategy is 'local'")
+			}
 			// TODO: This shouldn't be hardcoded
 			gitRepoOwner = "spotsie"
 			gitHost = "github.com"
 			gitRef = "refs/heads/local"
 		}
 
-		gitCfg, err := git.NewConfig(gitRepoOwner, gitHost, gitRef, gitToken)
+		gitCfg := newGitConfig()
+
+		if bump != "" && bump != "auto" {
+			panic(fmt.Sprintf("unknown bump mode: %s", bump))
+		}
+		if cUnbounded != "warn" && cUnbounded != "fail" {
+			panic(fmt.Sprintf("unknown c_unbounded_fields mode: %s", cUnbounded))
+		}
+
+		if buildWith != "" {
+			buildProtos()
+		}
+
+		proto := config.Proto{OutDir: protoOutDir, DescriptorSet: descSet, SrcDir: protoSrcDir}
+		releaseCfg := config.Release{
+			Bump:                  bump,
+			PreviousDescriptorSet: prevDescSet,
+			CheckBreaking:         checkBreaking,
+		}
+		targets := config.Targets{
+			Javascript: config.Javascript{
+				Scope:         jsScope,
+				Name:          jsName,
+				PerPackage:    jsPerPackage,
+				Registry:      jsRegistry,
+				RegistryToken: jsRegToken,
+				Compile:       jsCompile,
+				Protected:     jsProtected,
+			},
+			C: config.C{
+				IncludePrefix:   cIncludePfx,
+				Protected:       cProtected,
+				Nanopb:          cNanopb,
+				UnboundedFields: cUnbounded,
+			},
+			Python: config.Python{Protobuf: pyProtobuf, Grpcio: pyGrpcio, IndexDir: pyIndexDir},
+			Rust:   config.Rust{Prost: rustProst, Tonic: rustTonic, RegistryDir: rustRegistry},
+			Java: config.Java{
+				GroupID:       javaGroupID,
+				ArtifactID:    javaArtifact,
+				Protobuf:      javaProtobuf,
+				Grpc:          javaGrpc,
+				Kotlin:        javaKotlin,
+				RepositoryDir: javaRepoDir,
+			},
+			Swift:  config.Swift{Protobuf: swiftProtobuf},
+			CSharp: config.CSharp{Protobuf: csProtobuf, Grpc: csGrpc, FeedDir: csFeedDir},
+			Dart:   config.Dart{Protobuf: dartProtobuf, Grpc: dartGrpc},
+		}
+		distribute.Distribute(gitCfg, proto, releaseCfg, targets, dryRun, deploy, deployDir)
+	},
+}
+
+// buildProtos compiles protos into proto out dir, which is a temp dir if not set.
+// Descriptor set of compiled protos is used unless one is set explicitly.
+func buildProtos() {
+	if protoSrcDir == "" {
+		panic("PROTODIST_PROTO_SRC_DIR must be set when protos are built")
+	}
+
+	plugins := build.DefaultPlugins
+	if len(buildPlugins) > 0 {
+		plugins = nil
+		for _, p := range buildPlugins {
+			plugin, err := build.ParsePlugin(p)
+			if err != nil {
+				panic(err)
+			}
+			plugins = append(plugins, plugin)
+		}
+	}
+
+	if protoOutDir == "" {
+		var err error
+		protoOutDir, err = ioutil.TempDir("", "protodist-out-*")
 		if err != nil {
 			panic(err)
 		}
+	}
 
-		distribute.Distribute(gitCfg, protoOutDir, dryRun, deploy, deployDir)
-	},
+	buildCfg := config.Build{Compiler: buildWith, SourceDir: protoSrcDir, Includes: protoIncludes}
+	descriptorSetPath, err := build.Build(buildCfg, plugins, protoOutDir)
+	if err != nil {
+		panic(err)
+	}
+	if descSet == "" {
+		descSet = descriptorSetPath
+	}
+}
+
+// newGitConfig builds git config from flags
+func newGitConfig() git.Config {
+	provider, err := git.NewProvider(gitProvider, gitURLTmpl, gitUsername)
+	if err != nil {
+		panic(err)
+	}
+
+	gitCfg, err := git.NewConfig(gitRepoOwner, gitHost, gitRef, gitToken, provider)
+	if err != nil {
+		panic(err)
+	}
+
+	gitCfg.SSHKey = gitSSHKey
+	gitCfg.KnownHosts = gitKnownHost
+	gitCfg.CloneDepth = cloneDepth
+	gitCfg.SparseCheckout = cloneSparse
+	gitCfg.Monorepo = monorepo
+	if cloneCache != "" {
+		// git commands are run from clone dirs, mirror path can't be relative
+		gitCfg.MirrorDir, err = filepath.Abs(cloneCache)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if publish != git.PublishPush && publish != git.PublishPullRequest {
+		panic(fmt.Sprintf("unknown publish mode: %s", publish))
+	}
+	gitCfg.Publish = publish
+	gitCfg.PullRequestBase = prBase
+	gitCfg.AutoMerge = autoMerge
+
+	if createRepos || publish == git.PublishPullRequest {
+		forge, err := git.NewForge(gitCfg, gitAPIURL)
+		if err != nil {
+			panic(err)
+		}
+		gitCfg.Forge = forge
+	}
+
+	if createRepos {
+		gitCfg.CreateMissingRepos = true
+		gitCfg.NewRepo = git.RepoOptions{
+			Description:   repoDesc,
+			Visibility:    repoVisib,
+			DefaultBranch: repoBranch,
+		}
+	}
+
+	return gitCfg
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -98,7 +293,61 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&gitRef, "git_ref", "", "git ref (e.g refs/heads/foo-branch, refs/tags/foo-tag)")
 	rootCmd.PersistentFlags().StringVar(&gitHost, "git_host", "", "git host")
 	rootCmd.PersistentFlags().StringVar(&gitToken, "git_token", "", "git token")
+	rootCmd.PersistentFlags().StringVar(&gitProvider, "git_provider", "github", "git provider: github, gitlab, gitea or bitbucket")
+	rootCmd.PersistentFlags().StringVar(&gitURLTmpl, "git_url_template", "", "repo URL template for self-hosted setups, overrides git_provider (e.g https://{{ .Host }}/{{ .Owner }}/{{ .Repo }}.git)")
+	rootCmd.PersistentFlags().StringVar(&gitUsername, "git_username", "", "username sent along with git token when git_url_template is used")
+	rootCmd.PersistentFlags().StringVar(&gitSSHKey, "git_ssh_key", "", "path to SSH private key")
+	rootCmd.PersistentFlags().StringVar(&gitKnownHost, "git_known_hosts", "", "path to SSH known_hosts file, enables strict host key checking")
+	rootCmd.PersistentFlags().StringVar(&gitAPIURL, "git_api_url", "", "git forge API URL, defaults to the API of git_provider on git_host")
+	rootCmd.PersistentFlags().BoolVar(&createRepos, "create_missing_repos", false, "create missing target repos through git forge API")
+	rootCmd.PersistentFlags().StringVar(&repoVisib, "repo_visibility", "private", "visibility of created repos: public, private or internal")
+	rootCmd.PersistentFlags().StringVar(&repoDesc, "repo_description", "Protobuf package distributed by protodist", "description of created repos")
+	rootCmd.PersistentFlags().StringVar(&repoBranch, "repo_default_branch", "master", "default branch of created repos")
+	rootCmd.PersistentFlags().StringVar(&publish, "publish", "push", "publish mode: push (directly to git ref) or pr (open a pull request from protodist/<ref> branch)")
+	rootCmd.PersistentFlags().StringVar(&prBase, "pr_base_branch", "master", "base branch of pull requests")
+	rootCmd.PersistentFlags().BoolVar(&autoMerge, "pr_auto_merge", false, "enable auto-merge of pull requests")
+	rootCmd.PersistentFlags().IntVar(&cloneDepth, "clone_depth", 0, "clone target repos with limited history depth (e.g 1), full clone when 0")
+	rootCmd.PersistentFlags().BoolVar(&cloneSparse, "clone_sparse", false, "clone monorepo sparsely, only language dirs of distributed targets are checked out and their blobs fetched")
+	rootCmd.PersistentFlags().StringVar(&cloneCache, "clone_cache_dir", "", "directory with bare mirrors of target repos, kept between runs to speed up cloning")
+	rootCmd.PersistentFlags().StringVar(&monorepo, "monorepo", "", "distribute all packages through a single repo with go/<pkg>, js, c/<pkg>, python/<pkg>, rust/<pkg>, java/<pkg>, swift/<Target>, csharp/<pkg> and dart/<pkg> directories")
 	rootCmd.PersistentFlags().StringVar(&protoOutDir, "proto_out_dir", "", "proto output directory")
+	rootCmd.PersistentFlags().StringVar(&buildWith, "build", "", "compile protos before distributing them with: protoc or buf")
+	rootCmd.PersistentFlags().StringVar(&protoSrcDir, "proto_src_dir", "", "root directory of .proto sources, compiled when build is set, nanopb .options files of C packages are distributed from it")
+	rootCmd.PersistentFlags().StringSliceVar(&protoIncludes, "proto_include", nil, "additional proto include paths (protoc only)")
+	rootCmd.PersistentFlags().StringArrayVar(&buildPlugins, "build_plugin", nil, "protoc plugin as <name>=<out_dir>[:<opt>] (e.g go=go:paths=source_relative), defaults to go, go-grpc, ts_proto and nanopb")
+	rootCmd.PersistentFlags().StringVar(&descSet, "descriptor_set", "", "binary FileDescriptorSet of compiled protos (protoc --descriptor_set_out)")
+	rootCmd.PersistentFlags().StringVar(&bump, "bump", "", "version bump mode: auto (release versions are computed from proto changes), git_ref is used when empty")
+	rootCmd.PersistentFlags().StringVar(&prevDescSet, "previous_descriptor_set", "", "descriptor set of the previous release, defaults to the one published with the latest release")
+	rootCmd.PersistentFlags().StringVar(&checkBreaking, "check_breaking", "", "fail the release on breaking changes of a category: package, wire_json or wire")
+	rootCmd.PersistentFlags().StringVar(&jsScope, "js_scope", "", "npm scope of javascript package (e.g @org)")
+	rootCmd.PersistentFlags().StringVar(&jsName, "js_name", "proto-all-js", "npm name of javascript package")
+	rootCmd.PersistentFlags().BoolVar(&jsPerPackage, "js_per_package", false, "distribute each proto package as a separate npm package (<js_scope>/proto-<pkg>) instead of a single js_name package")
+	rootCmd.PersistentFlags().StringVar(&jsRegistry, "js_registry", "", "npm registry URL, tagged releases of javascript packages are published to it")
+	rootCmd.PersistentFlags().StringVar(&jsRegToken, "js_registry_token", "", "npm registry auth token")
+	rootCmd.PersistentFlags().StringSliceVar(&jsProtected, "js_protected", append([]string{"package.override.json"}, util.DefaultProtected...), "hand-maintained files of javascript packages (patterns relative to package dir, or any dir below it), which aren't removed along with stale generated files. Other files of package dirs which aren't generated are removed. package.json is always generated, its hand-maintained fields are merged from package.override.json")
+	rootCmd.PersistentFlags().BoolVar(&jsCompile, "js_compile", false, "compile typescript of javascript packages into ESM and CommonJS modules with declarations (requires tsc or esbuild)")
+	rootCmd.PersistentFlags().StringVar(&cIncludePfx, "c_include_prefix", "", "dir of C headers (e.g proto for #include \"proto/<pkg>/foo.pb.h\"), headers reside in <pkg> dir by default")
+	rootCmd.PersistentFlags().StringSliceVar(&cProtected, "c_protected", util.DefaultProtected, "hand-maintained files of C packages (patterns relative to package dir, or any dir below it), which aren't removed along with stale generated files. Other files of package dirs which aren't generated are removed")
+	rootCmd.PersistentFlags().StringVar(&cNanopb, "c_nanopb_version", "0.4.4", "nanopb version of C library manifests (CMake fetches nanopb-<version> tag)")
+	rootCmd.PersistentFlags().StringVar(&cUnbounded, "c_unbounded_fields", "warn", "unbounded string, bytes and repeated fields of protos without nanopb .options file, which are generated as callbacks (warn or fail)")
+	rootCmd.PersistentFlags().StringVar(&pyProtobuf, "python_protobuf_version", ">=3.14,<4", "protobuf requirement of python packages")
+	rootCmd.PersistentFlags().StringVar(&pyGrpcio, "python_grpcio_version", ">=1.35,<2", "grpcio requirement of python packages with grpc stubs")
+	rootCmd.PersistentFlags().StringVar(&pyIndexDir, "python_index_dir", "", "build wheel and sdist of python packages into a local directory index")
+	rootCmd.PersistentFlags().StringVar(&rustProst, "rust_prost_version", "0.7", "prost version requirement of rust crates")
+	rootCmd.PersistentFlags().StringVar(&rustTonic, "rust_tonic_version", "0.4", "tonic version requirement of rust crates with grpc services")
+	rootCmd.PersistentFlags().StringVar(&rustRegistry, "rust_registry_dir", "", "package rust crates into a cargo local registry directory")
+	rootCmd.PersistentFlags().StringVar(&javaGroupID, "java_group_id", "{{ .Owner }}.proto", "groupId template of maven projects, with .Host, .Owner and .Package fields")
+	rootCmd.PersistentFlags().StringVar(&javaArtifact, "java_artifact_id", "proto-{{ .Package }}", "artifactId template of maven projects, with .Host, .Owner and .Package fields")
+	rootCmd.PersistentFlags().StringVar(&javaProtobuf, "java_protobuf_version", "3.14.0", "protobuf-java (and protobuf-kotlin) version of maven projects")
+	rootCmd.PersistentFlags().StringVar(&javaGrpc, "java_grpc_version", "1.35.0", "grpc-java version of maven projects with grpc stubs")
+	rootCmd.PersistentFlags().StringVar(&javaKotlin, "java_kotlin_version", "1.4.30", "kotlin version of maven projects with kotlin sources")
+	rootCmd.PersistentFlags().StringVar(&javaRepoDir, "java_repository_dir", "", "deploy jars and poms into a file based maven repository directory (requires mvn)")
+	rootCmd.PersistentFlags().StringVar(&swiftProtobuf, "swift_protobuf_version", "1.15.0", "minimum swift-protobuf version of swift package")
+	rootCmd.PersistentFlags().StringVar(&csProtobuf, "csharp_protobuf_version", "3.14.0", "Google.Protobuf version of .NET projects")
+	rootCmd.PersistentFlags().StringVar(&csGrpc, "csharp_grpc_version", "2.35.0", "Grpc.Core.Api version of .NET projects with grpc stubs")
+	rootCmd.PersistentFlags().StringVar(&csFeedDir, "csharp_feed_dir", "", "pack .NET projects into a local NuGet folder feed (requires dotnet)")
+	rootCmd.PersistentFlags().StringVar(&dartProtobuf, "dart_protobuf_version", "^2.0.0", "protobuf version constraint of dart packages")
+	rootCmd.PersistentFlags().StringVar(&dartGrpc, "dart_grpc_version", "^3.0.0", "grpc version constraint of dart packages with grpc stubs")
 	rootCmd.PersistentFlags().StringVar(&deploy, "deploy", "git", "deploy to: git or local")
 	rootCmd.PersistentFlags().StringVar(&deployDir, "deploy_dir", "", "local deploy directory")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "show verbose logs")
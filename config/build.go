@@ -0,0 +1,10 @@
+package config
+
+type Build struct {
+	// protoc or buf, build stage is skipped when empty
+	Compiler string
+	// Root dir of .proto sources
+	SourceDir string
+	// Additional include paths (protoc only)
+	Includes []string
+}
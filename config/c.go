@@ -0,0 +1,13 @@
+package config
+
+type C struct {
+	// Headers are placed under IncludePrefix dir (e.g proto for #include "proto/foo/foo.pb.h"), include directives are rewritten accordingly
+	IncludePrefix string
+	// Hand-maintained files (patterns relative to package dir, or any dir below it) which aren't removed when syncing generated files
+	Protected []string
+	// Nanopb runtime version of library manifests (e.g 0.4.4)
+	Nanopb string
+	// Unbounded string, bytes and repeated fields of protos without .options are generated as callbacks,
+	// they are reported with a warning or fail the distribution (warn or fail)
+	UnboundedFields string
+}
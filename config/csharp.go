@@ -0,0 +1,9 @@
+package config
+
+type CSharp struct {
+	// Versions of Google.Protobuf and Grpc.Core.Api packages
+	Protobuf string
+	Grpc     string
+	// Packages are packed into a local NuGet folder feed in FeedDir when set
+	FeedDir string
+}
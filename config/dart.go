@@ -0,0 +1,7 @@
+package config
+
+type Dart struct {
+	// Version constraints of protobuf and grpc packages (e.g ^2.0.0)
+	Protobuf string
+	Grpc     string
+}
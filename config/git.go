@@ -2,4 +2,8 @@ package config
 
 type Proto struct {
 	OutDir string
+	// Binary FileDescriptorSet of compiled protos, optional
+	DescriptorSet string
+	// Root dir of .proto sources, optional. Nanopb .options files are distributed from it
+	SrcDir string
 }
@@ -0,0 +1,13 @@
+package config
+
+type Java struct {
+	// Templates of maven coordinates, with access to .Host, .Owner and .Package fields
+	GroupID    string
+	ArtifactID string
+	// Dependency versions
+	Protobuf string
+	Grpc     string
+	Kotlin   string
+	// Jars and poms are deployed into a file based maven repository in RepositoryDir when set
+	RepositoryDir string
+}
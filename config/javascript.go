@@ -0,0 +1,17 @@
+package config
+
+type Javascript struct {
+	// npm package name, with an optional scope (e.g @org)
+	Scope string
+	Name  string
+	// Each proto package is distributed as a separate npm package, named <Scope>/proto-<pkg>
+	PerPackage bool
+	// Tagged releases are published to npm Registry (e.g https://registry.npmjs.org) when set
+	Registry      string
+	RegistryToken string
+	// TypeScript sources are compiled into dist/esm, dist/cjs and declarations in dist/types
+	Compile bool
+	// Hand-maintained files (patterns relative to npm package dir, or any dir below it) which aren't removed when syncing
+	// generated files. package.json is always generated, fields of package.override.json are merged into it
+	Protected []string
+}
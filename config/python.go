@@ -0,0 +1,9 @@
+package config
+
+type Python struct {
+	// Requirement specifiers of runtime dependencies (e.g >=3.14,<4)
+	Protobuf string
+	Grpcio   string
+	// Wheel and sdist of each package are built into <IndexDir>/<project> when set, pip can install them with --find-links
+	IndexDir string
+}
@@ -0,0 +1,10 @@
+package config
+
+type Release struct {
+	// Version bump mode, "auto" computes release versions from proto changes
+	Bump string
+	// Descriptor set of the previous release, defaults to the one published with the latest release
+	PreviousDescriptorSet string
+	// Breaking change category which fails the release, check is disabled when empty
+	CheckBreaking string
+}
@@ -0,0 +1,9 @@
+package config
+
+type Rust struct {
+	// Version requirements of prost and tonic crates (e.g 0.7)
+	Prost string
+	Tonic string
+	// Crates are packaged into a cargo local registry in RegistryDir when set
+	RegistryDir string
+}
@@ -0,0 +1,6 @@
+package config
+
+type Swift struct {
+	// Minimum version of swift-protobuf package, up to next major version
+	Protobuf string
+}
@@ -0,0 +1,13 @@
+package config
+
+// Targets holds config of language targets
+type Targets struct {
+	Javascript Javascript
+	C          C
+	Python     Python
+	Rust       Rust
+	Java       Java
+	Swift      Swift
+	CSharp     CSharp
+	Dart       Dart
+}
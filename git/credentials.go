@@ -0,0 +1,115 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+)
+
+// askPassScript answers git's username and password prompts from the environment,
+// so that credentials never end up in remote URLs or .git/config
+const askPassScript = `#!/bin/sh
+case "$1" in
+Username*) echo "$PROTODIST_ASKPASS_USERNAME" ;;
+*) echo "$PROTODIST_ASKPASS_PASSWORD" ;;
+esac
+`
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// gitEnv holds credentials of git commands, it is set only on git commands, so that
+// other processes (e.g npm, mvn, protoc plugins) never inherit the token
+var gitEnv []string
+
+// stderr is used by all git commands, secrets are scrubbed from it
+var stderr io.Writer = scrubWriter{w: os.Stderr}
+var stdout io.Writer = scrubWriter{w: os.Stdout}
+
+// RegisterSecret marks a value which should never be printed
+func RegisterSecret(secret string) {
+	if secret == "" {
+		return
+	}
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets = append(secrets, secret)
+}
+
+// Scrub replaces registered secrets in s
+func Scrub(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, "***")
+	}
+
+	return s
+}
+
+type scrubWriter struct {
+	w io.Writer
+}
+
+func (s scrubWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(s.w, Scrub(string(p))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// gitCommand returns a git command, with credentials set up by SetupCredentials in its environment
+func gitCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), gitEnv...)
+	return cmd
+}
+
+// SetupCredentials configures the environment of git commands.
+// HTTPS token is provided through a GIT_ASKPASS shim written to dir,
+// SSH key and known_hosts file are set through GIT_SSH_COMMAND.
+func SetupCredentials(cfg Config, dir string) error {
+	gitEnv = nil
+	if cfg.Token != "" {
+		RegisterSecret(cfg.Token)
+
+		askPassPath := path.Join(dir, "protodist-askpass.sh")
+		if err := ioutil.WriteFile(askPassPath, []byte(askPassScript), 0700); err != nil {
+			return fmt.Errorf("failed to write askpass script: %s", err)
+		}
+
+		username, password := cfg.Provider.Credentials(cfg.Token)
+		gitEnv = append(gitEnv,
+			"GIT_ASKPASS="+askPassPath,
+			"GIT_TERMINAL_PROMPT=0",
+			"PROTODIST_ASKPASS_USERNAME="+username,
+			"PROTODIST_ASKPASS_PASSWORD="+password,
+		)
+	}
+
+	if cfg.SSHKey != "" || cfg.KnownHosts != "" {
+		sshCommand := []string{"ssh"}
+		if cfg.SSHKey != "" {
+			sshCommand = append(sshCommand, "-i", shellQuote(cfg.SSHKey), "-o", "IdentitiesOnly=yes")
+		}
+		if cfg.KnownHosts != "" {
+			sshCommand = append(sshCommand, "-o", "UserKnownHostsFile="+shellQuote(cfg.KnownHosts), "-o", "StrictHostKeyChecking=yes")
+		}
+		gitEnv = append(gitEnv, "GIT_SSH_COMMAND="+strings.Join(sshCommand, " "))
+	}
+
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
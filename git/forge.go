@@ -0,0 +1,229 @@
+package git
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Forge talks to the REST API of a git forge
+type Forge interface {
+	RepoExists(owner, repo string) (bool, error)
+	CreateRepo(owner string, opts RepoOptions) error
+	// OpenPullRequest opens a pull (merge) request, or updates the one which is already open for the same branches.
+	// URL of the pull request is returned.
+	OpenPullRequest(owner, repo string, pr PullRequest) (string, error)
+}
+
+// PullRequest from Head branch into Base branch
+type PullRequest struct {
+	Title string
+	Body  string
+	Head  string
+	Base  string
+	// Merge the pull request automatically once checks pass
+	AutoMerge bool
+}
+
+// RepoOptions of repos created by protodist
+type RepoOptions struct {
+	Name          string
+	Description   string
+	Visibility    string // public, private or internal
+	DefaultBranch string
+}
+
+// NewForge returns a forge API client for the configured provider.
+// If apiURL is empty, the default API URL of the provider on cfg.Host is used.
+func NewForge(cfg Config, apiURL string) (Forge, error) {
+	switch cfg.Provider.(type) {
+	case GitHub:
+		if apiURL == "" {
+			apiURL = "https://" + cfg.Host + "/api/v3"
+			if cfg.Host == "github.com" {
+				apiURL = "https://api.github.com"
+			}
+		}
+		return GitHubAPI{client: newAPIClient(apiURL, "Authorization", "token "+cfg.Token)}, nil
+	case GitLab:
+		if apiURL == "" {
+			apiURL = "https://" + cfg.Host + "/api/v4"
+		}
+		return GitLabAPI{client: newAPIClient(apiURL, "Authorization", "Bearer "+cfg.Token)}, nil
+	case Gitea:
+		if apiURL == "" {
+			apiURL = "https://" + cfg.Host + "/api/v1"
+		}
+		return GiteaAPI{client: newAPIClient(apiURL, "Authorization", "token "+cfg.Token)}, nil
+	}
+
+	return nil, fmt.Errorf("git provider %T doesn't support forge API", cfg.Provider)
+}
+
+type apiClient struct {
+	baseURL    string
+	authHeader string
+	authValue  string
+	httpClient *http.Client
+}
+
+func newAPIClient(baseURL, authHeader, authValue string) apiClient {
+	return apiClient{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		authHeader: authHeader,
+		authValue:  authValue,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends a JSON request and decodes the response into out (if not nil).
+// Status code is returned for responses which are not 2xx, along with an error.
+func (c apiClient) do(method, endpoint string, in interface{}, out interface{}) (int, error) {
+	var body *bytes.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(payload)
+	} else {
+		body = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+endpoint, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(c.authHeader, c.authValue)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%s %s: %s", method, endpoint, Scrub(err.Error()))
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%s %s: %s: %s", method, endpoint, resp.Status, Scrub(string(respBody)))
+	}
+
+	if out != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("%s %s: failed to decode response: %s", method, endpoint, err)
+		}
+	}
+
+	return resp.StatusCode, nil
+}
+
+// exists performs a GET request, 404 is reported as false
+func (c apiClient) exists(endpoint string) (bool, error) {
+	status, err := c.do(http.MethodGet, endpoint, nil, nil)
+	if status == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// checkUserOwner fails when repos of a user owner would be created under another user. Repos of users are created
+// through /user/repos (GitHub, Gitea), which always creates them under the user the token belongs to.
+func (c apiClient) checkUserOwner(owner string) error {
+	var user struct {
+		Login string `json:"login"`
+	}
+	if _, err := c.do(http.MethodGet, "/user", nil, &user); err != nil {
+		return err
+	}
+	if !strings.EqualFold(user.Login, owner) {
+		return fmt.Errorf("repos can only be created under user %s, which the token belongs to, not under user %s", user.Login, owner)
+	}
+
+	return nil
+}
+
+// CloneOrCreate clones a repo. When cfg.CreateMissingRepos is set and repo doesn't exist,
+// it is created through the forge API, and an initial commit is pushed to its default branch.
+// In dry run, missing repo isn't created, an empty local repo is initialized instead.
+func CloneOrCreate(cfg Config, repoName string, branch string, dryRun bool) {
+	if !cfg.CreateMissingRepos {
+		Clone(cfg, repoName, branch)
+		return
+	}
+
+	exists, err := cfg.Forge.RepoExists(cfg.Owner, repoName)
+	if err != nil {
+		panic(fmt.Errorf("failed to check if repo %s exists: %s", repoName, err))
+	}
+
+	if !exists {
+		if dryRun {
+			fmt.Printf("dry run: repo %s doesn't exist, it won't be created\n", repoName)
+			Init(repoName, branch)
+			return
+		}
+
+		opts := cfg.NewRepo
+		opts.Name = repoName
+		fmt.Printf("creating repo %s\n", repoName)
+		if err := cfg.Forge.CreateRepo(cfg.Owner, opts); err != nil {
+			panic(fmt.Errorf("failed to create repo %s: %s", repoName, err))
+		}
+		pushInitialCommit(cfg.GetRepoURL(repoName), opts.DefaultBranch)
+	}
+
+	Clone(cfg, repoName, branch)
+}
+
+// Init creates an empty local repo with a checked out branch
+func Init(repoName string, branch string) {
+	repoDir := path.Join(os.TempDir(), repoName)
+	for _, args := range [][]string{
+		{"init", repoDir},
+		{"-C", repoDir, "symbolic-ref", "HEAD", "refs/heads/" + branch},
+	} {
+		cmd := gitCommand(args...)
+		cmd.Stderr = stderr
+		if err := cmd.Run(); err != nil {
+			panic(fmt.Errorf("failed to init repo %s: %s", repoName, err))
+		}
+	}
+}
+
+func pushInitialCommit(repoUrl string, branch string) {
+	initDir, err := ioutil.TempDir(os.TempDir(), "protodist-init-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(initDir)
+
+	for _, args := range [][]string{
+		{"init"},
+		{"symbolic-ref", "HEAD", "refs/heads/" + branch},
+		{"commit", "--allow-empty", "-m", "initial commit"},
+		{"remote", "add", "origin", repoUrl},
+		{"push", "origin", branch},
+	} {
+		cmd := gitCommand(args...)
+		cmd.Dir = initDir
+		cmd.Stderr = stderr
+		if err := cmd.Run(); err != nil {
+			panic(fmt.Errorf("failed to push initial commit: %s: %s", Scrub(repoUrl), err))
+		}
+	}
+}
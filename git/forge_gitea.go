@@ -0,0 +1,95 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Page size of Gitea list endpoints
+const giteaPageLimit = 50
+
+// GiteaAPI
+type GiteaAPI struct {
+	client apiClient
+}
+
+func (g GiteaAPI) RepoExists(owner, repo string) (bool, error) {
+	return g.client.exists(fmt.Sprintf("/repos/%s/%s", url.PathEscape(owner), url.PathEscape(repo)))
+}
+
+func (g GiteaAPI) CreateRepo(owner string, opts RepoOptions) error {
+	isOrg, err := g.client.exists("/orgs/" + url.PathEscape(owner))
+	if err != nil {
+		return err
+	}
+
+	endpoint := "/user/repos"
+	if isOrg {
+		endpoint = fmt.Sprintf("/orgs/%s/repos", url.PathEscape(owner))
+	} else if err := g.client.checkUserOwner(owner); err != nil {
+		return err
+	}
+
+	body := map[string]interface{}{
+		"name":           opts.Name,
+		"description":    opts.Description,
+		"private":        opts.Visibility != "public",
+		"default_branch": opts.DefaultBranch,
+	}
+	_, err = g.client.do(http.MethodPost, endpoint, body, nil)
+	return err
+}
+
+func (g GiteaAPI) OpenPullRequest(owner, repo string, pr PullRequest) (string, error) {
+	type branch struct {
+		Ref string `json:"ref"`
+	}
+	type pullRequest struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+		Head    branch `json:"head"`
+		Base    branch `json:"base"`
+	}
+
+	pullsEndpoint := fmt.Sprintf("/repos/%s/%s/pulls", url.PathEscape(owner), url.PathEscape(repo))
+	// Gitea can't filter pull requests by head branch, open pull requests are listed page by page
+	var pull *pullRequest
+	for page := 1; pull == nil; page++ {
+		var openPulls []pullRequest
+		if _, err := g.client.do(http.MethodGet, fmt.Sprintf("%s?state=open&page=%d&limit=%d", pullsEndpoint, page, giteaPageLimit), nil, &openPulls); err != nil {
+			return "", err
+		}
+		for i := range openPulls {
+			if openPulls[i].Head.Ref == pr.Head && openPulls[i].Base.Ref == pr.Base {
+				pull = &openPulls[i]
+				break
+			}
+		}
+		if len(openPulls) < giteaPageLimit {
+			break
+		}
+	}
+
+	if pull != nil {
+		body := map[string]interface{}{"title": pr.Title, "body": pr.Body}
+		if _, err := g.client.do(http.MethodPatch, fmt.Sprintf("%s/%d", pullsEndpoint, pull.Number), body, nil); err != nil {
+			return "", err
+		}
+	} else {
+		pull = &pullRequest{}
+		body := map[string]interface{}{"title": pr.Title, "body": pr.Body, "head": pr.Head, "base": pr.Base}
+		if _, err := g.client.do(http.MethodPost, pullsEndpoint, body, pull); err != nil {
+			return "", err
+		}
+	}
+
+	if pr.AutoMerge {
+		body := map[string]interface{}{"Do": "merge", "merge_when_checks_succeed": true}
+		if _, err := g.client.do(http.MethodPost, fmt.Sprintf("%s/%d/merge", pullsEndpoint, pull.Number), body, nil); err != nil {
+			return pull.HTMLURL, fmt.Errorf("failed to enable auto-merge: %s", err)
+		}
+	}
+
+	return pull.HTMLURL, nil
+}
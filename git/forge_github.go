@@ -0,0 +1,111 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GitHubAPI, works with github.com and GitHub Enterprise
+type GitHubAPI struct {
+	client apiClient
+}
+
+func (g GitHubAPI) RepoExists(owner, repo string) (bool, error) {
+	return g.client.exists(fmt.Sprintf("/repos/%s/%s", url.PathEscape(owner), url.PathEscape(repo)))
+}
+
+func (g GitHubAPI) CreateRepo(owner string, opts RepoOptions) error {
+	var user struct {
+		Type string `json:"type"`
+	}
+	if _, err := g.client.do(http.MethodGet, "/users/"+url.PathEscape(owner), nil, &user); err != nil {
+		return err
+	}
+
+	endpoint := "/user/repos"
+	if user.Type == "Organization" {
+		endpoint = fmt.Sprintf("/orgs/%s/repos", url.PathEscape(owner))
+	} else if err := g.client.checkUserOwner(owner); err != nil {
+		return err
+	}
+
+	// Default branch can't be set on creation, it becomes the first pushed branch
+	body := map[string]interface{}{
+		"name":        opts.Name,
+		"description": opts.Description,
+		"private":     opts.Visibility != "public",
+		"visibility":  opts.Visibility,
+	}
+	_, err := g.client.do(http.MethodPost, endpoint, body, nil)
+	return err
+}
+
+func (g GitHubAPI) OpenPullRequest(owner, repo string, pr PullRequest) (string, error) {
+	type pullRequest struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+		NodeID  string `json:"node_id"`
+	}
+
+	pullsEndpoint := fmt.Sprintf("/repos/%s/%s/pulls", url.PathEscape(owner), url.PathEscape(repo))
+	query := url.Values{
+		"state": {"open"},
+		"head":  {owner + ":" + pr.Head},
+		"base":  {pr.Base},
+	}
+	var openPulls []pullRequest
+	if _, err := g.client.do(http.MethodGet, pullsEndpoint+"?"+query.Encode(), nil, &openPulls); err != nil {
+		return "", err
+	}
+
+	var pull pullRequest
+	if len(openPulls) > 0 {
+		pull = openPulls[0]
+		body := map[string]interface{}{"title": pr.Title, "body": pr.Body}
+		if _, err := g.client.do(http.MethodPatch, fmt.Sprintf("%s/%d", pullsEndpoint, pull.Number), body, nil); err != nil {
+			return "", err
+		}
+	} else {
+		body := map[string]interface{}{"title": pr.Title, "body": pr.Body, "head": pr.Head, "base": pr.Base}
+		if _, err := g.client.do(http.MethodPost, pullsEndpoint, body, &pull); err != nil {
+			return "", err
+		}
+	}
+
+	if pr.AutoMerge {
+		if err := g.enableAutoMerge(pull.NodeID); err != nil {
+			return pull.HTMLURL, fmt.Errorf("failed to enable auto-merge: %s", err)
+		}
+	}
+
+	return pull.HTMLURL, nil
+}
+
+// Auto-merge is only available through GraphQL API
+func (g GitHubAPI) enableAutoMerge(pullRequestID string) error {
+	graphql := g.client
+	if strings.HasSuffix(graphql.baseURL, "/api/v3") {
+		graphql.baseURL = strings.TrimSuffix(graphql.baseURL, "/v3")
+	}
+
+	body := map[string]interface{}{
+		"query":     `mutation($id: ID!) { enablePullRequestAutoMerge(input: {pullRequestId: $id}) { clientMutationId } }`,
+		"variables": map[string]string{"id": pullRequestID},
+	}
+	var resp struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if _, err := graphql.do(http.MethodPost, "/graphql", body, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		return errors.New(resp.Errors[0].Message)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// GitLabAPI, owner is a namespace path which can contain nested subgroups
+type GitLabAPI struct {
+	client apiClient
+}
+
+func (g GitLabAPI) RepoExists(owner, repo string) (bool, error) {
+	return g.client.exists("/projects/" + url.PathEscape(path.Join(owner, repo)))
+}
+
+func (g GitLabAPI) CreateRepo(owner string, opts RepoOptions) error {
+	var namespace struct {
+		ID int `json:"id"`
+	}
+	if _, err := g.client.do(http.MethodGet, "/namespaces/"+url.PathEscape(owner), nil, &namespace); err != nil {
+		return err
+	}
+
+	body := map[string]interface{}{
+		"name":           opts.Name,
+		"path":           opts.Name,
+		"namespace_id":   namespace.ID,
+		"description":    opts.Description,
+		"visibility":     opts.Visibility,
+		"default_branch": opts.DefaultBranch,
+	}
+	_, err := g.client.do(http.MethodPost, "/projects", body, nil)
+	return err
+}
+
+func (g GitLabAPI) OpenPullRequest(owner, repo string, pr PullRequest) (string, error) {
+	type mergeRequest struct {
+		IID    int    `json:"iid"`
+		WebURL string `json:"web_url"`
+	}
+
+	mergeRequestsEndpoint := fmt.Sprintf("/projects/%s/merge_requests", url.PathEscape(path.Join(owner, repo)))
+	query := url.Values{
+		"state":         {"opened"},
+		"source_branch": {pr.Head},
+		"target_branch": {pr.Base},
+	}
+	var openMergeRequests []mergeRequest
+	if _, err := g.client.do(http.MethodGet, mergeRequestsEndpoint+"?"+query.Encode(), nil, &openMergeRequests); err != nil {
+		return "", err
+	}
+
+	var mr mergeRequest
+	if len(openMergeRequests) > 0 {
+		mr = openMergeRequests[0]
+		body := map[string]interface{}{"title": pr.Title, "description": pr.Body}
+		if _, err := g.client.do(http.MethodPut, fmt.Sprintf("%s/%d", mergeRequestsEndpoint, mr.IID), body, nil); err != nil {
+			return "", err
+		}
+	} else {
+		body := map[string]interface{}{
+			"title":                pr.Title,
+			"description":          pr.Body,
+			"source_branch":        pr.Head,
+			"target_branch":        pr.Base,
+			"remove_source_branch": true,
+		}
+		if _, err := g.client.do(http.MethodPost, mergeRequestsEndpoint, body, &mr); err != nil {
+			return "", err
+		}
+	}
+
+	if pr.AutoMerge {
+		body := map[string]interface{}{"merge_when_pipeline_succeeds": true}
+		if _, err := g.client.do(http.MethodPut, fmt.Sprintf("%s/%d/merge", mergeRequestsEndpoint, mr.IID), body, nil); err != nil {
+			return mr.WebURL, fmt.Errorf("failed to enable auto-merge: %s", err)
+		}
+	}
+
+	return mr.WebURL, nil
+}
@@ -0,0 +1,372 @@
+package git
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// fakeRequest is a request received by fakeForge
+type fakeRequest struct {
+	Method string
+	Path   string
+	Body   map[string]interface{}
+}
+
+// fakeForge is a local HTTP fake of a forge API, responses are given by "<METHOD> <request URI>"
+type fakeForge struct {
+	*httptest.Server
+	responses map[string]fakeResponse
+	requests  []fakeRequest
+}
+
+type fakeResponse struct {
+	status int
+	body   interface{}
+}
+
+func newFakeForge(t *testing.T, responses map[string]fakeResponse) *fakeForge {
+	forge := &fakeForge{responses: responses}
+	forge.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := fakeRequest{Method: r.Method, Path: r.URL.RequestURI()}
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &request.Body); err != nil {
+				t.Errorf("%s %s: invalid JSON body: %s", r.Method, request.Path, err)
+			}
+		}
+		forge.requests = append(forge.requests, request)
+
+		response, ok := forge.responses[r.Method+" "+request.Path]
+		if !ok {
+			t.Errorf("unexpected request: %s %s", r.Method, request.Path)
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		w.WriteHeader(response.status)
+		if response.body != nil {
+			json.NewEncoder(w).Encode(response.body)
+		}
+	}))
+	t.Cleanup(forge.Close)
+
+	return forge
+}
+
+// request returns the last request received with a method and path
+func (f *fakeForge) request(t *testing.T, method, path string) fakeRequest {
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		if f.requests[i].Method == method && f.requests[i].Path == path {
+			return f.requests[i]
+		}
+	}
+	t.Fatalf("no %s %s request received", method, path)
+	return fakeRequest{}
+}
+
+func newTestForge(t *testing.T, provider Provider, server *fakeForge) Forge {
+	forge, err := NewForge(Config{Provider: provider, Token: "token"}, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return forge
+}
+
+func TestRepoExists(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		path     string
+	}{
+		{"github", GitHub{}, "/repos/org/proto-foo-go"},
+		{"gitlab", GitLab{}, "/projects/org%2Fproto-foo-go"},
+		{"gitea", Gitea{}, "/repos/org/proto-foo-go"},
+	}
+
+	for _, test := range tests {
+		for status, want := range map[int]bool{http.StatusOK: true, http.StatusNotFound: false} {
+			server := newFakeForge(t, map[string]fakeResponse{
+				"GET " + test.path: {status: status, body: map[string]string{}},
+			})
+			exists, err := newTestForge(t, test.provider, server).RepoExists("org", "proto-foo-go")
+			if err != nil {
+				t.Errorf("%s RepoExists() with status %d: %s", test.name, status, err)
+			}
+			if exists != want {
+				t.Errorf("%s RepoExists() with status %d = %t, want %t", test.name, status, exists, want)
+			}
+		}
+	}
+
+	server := newFakeForge(t, map[string]fakeResponse{
+		"GET /repos/org/proto-foo-go": {status: http.StatusInternalServerError},
+	})
+	if _, err := newTestForge(t, GitHub{}, server).RepoExists("org", "proto-foo-go"); err == nil {
+		t.Error("expected an error for status 500")
+	}
+}
+
+func TestCreateRepo(t *testing.T) {
+	opts := RepoOptions{Name: "proto-foo-go", Description: "Generated protobuf code", Visibility: "private", DefaultBranch: "main"}
+
+	tests := []struct {
+		name      string
+		provider  Provider
+		responses map[string]fakeResponse
+		endpoint  string
+		want      map[string]interface{}
+	}{
+		{
+			name:     "github organization",
+			provider: GitHub{},
+			responses: map[string]fakeResponse{
+				"GET /users/org":       {status: http.StatusOK, body: map[string]string{"type": "Organization"}},
+				"POST /orgs/org/repos": {status: http.StatusCreated},
+			},
+			endpoint: "/orgs/org/repos",
+			want:     map[string]interface{}{"name": "proto-foo-go", "description": "Generated protobuf code", "private": true, "visibility": "private"},
+		},
+		{
+			name:     "github user",
+			provider: GitHub{},
+			responses: map[string]fakeResponse{
+				"GET /users/org":   {status: http.StatusOK, body: map[string]string{"type": "User"}},
+				"GET /user":        {status: http.StatusOK, body: map[string]string{"login": "Org"}},
+				"POST /user/repos": {status: http.StatusCreated},
+			},
+			endpoint: "/user/repos",
+			want:     map[string]interface{}{"name": "proto-foo-go", "description": "Generated protobuf code", "private": true, "visibility": "private"},
+		},
+		{
+			name:     "gitlab group",
+			provider: GitLab{},
+			responses: map[string]fakeResponse{
+				"GET /namespaces/org": {status: http.StatusOK, body: map[string]int{"id": 42}},
+				"POST /projects":      {status: http.StatusCreated},
+			},
+			endpoint: "/projects",
+			want: map[string]interface{}{
+				"name": "proto-foo-go", "path": "proto-foo-go", "namespace_id": float64(42),
+				"description": "Generated protobuf code", "visibility": "private", "default_branch": "main",
+			},
+		},
+		{
+			name:     "gitea organization",
+			provider: Gitea{},
+			responses: map[string]fakeResponse{
+				"GET /orgs/org":        {status: http.StatusOK, body: map[string]string{}},
+				"POST /orgs/org/repos": {status: http.StatusCreated},
+			},
+			endpoint: "/orgs/org/repos",
+			want:     map[string]interface{}{"name": "proto-foo-go", "description": "Generated protobuf code", "private": true, "default_branch": "main"},
+		},
+		{
+			name:     "gitea user",
+			provider: Gitea{},
+			responses: map[string]fakeResponse{
+				"GET /orgs/org":    {status: http.StatusNotFound},
+				"GET /user":        {status: http.StatusOK, body: map[string]string{"login": "org"}},
+				"POST /user/repos": {status: http.StatusCreated},
+			},
+			endpoint: "/user/repos",
+			want:     map[string]interface{}{"name": "proto-foo-go", "description": "Generated protobuf code", "private": true, "default_branch": "main"},
+		},
+	}
+
+	for _, test := range tests {
+		server := newFakeForge(t, test.responses)
+		if err := newTestForge(t, test.provider, server).CreateRepo("org", opts); err != nil {
+			t.Errorf("%s CreateRepo(): %s", test.name, err)
+			continue
+		}
+		if got := server.request(t, http.MethodPost, test.endpoint).Body; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s CreateRepo() payload = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// Public repos aren't private
+	server := newFakeForge(t, map[string]fakeResponse{
+		"GET /users/org":   {status: http.StatusOK, body: map[string]string{"type": "User"}},
+		"GET /user":        {status: http.StatusOK, body: map[string]string{"login": "org"}},
+		"POST /user/repos": {status: http.StatusCreated},
+	})
+	public := opts
+	public.Visibility = "public"
+	if err := newTestForge(t, GitHub{}, server).CreateRepo("org", public); err != nil {
+		t.Fatal(err)
+	}
+	if body := server.request(t, http.MethodPost, "/user/repos").Body; body["private"] != false || body["visibility"] != "public" {
+		t.Errorf("CreateRepo() of public repo payload = %v", body)
+	}
+
+	// Repos of users are created under the user of the token, other users are refused
+	otherUserTests := []struct {
+		name      string
+		provider  Provider
+		responses map[string]fakeResponse
+	}{
+		{
+			name:     "github",
+			provider: GitHub{},
+			responses: map[string]fakeResponse{
+				"GET /users/org": {status: http.StatusOK, body: map[string]string{"type": "User"}},
+				"GET /user":      {status: http.StatusOK, body: map[string]string{"login": "bot"}},
+			},
+		},
+		{
+			name:     "gitea",
+			provider: Gitea{},
+			responses: map[string]fakeResponse{
+				"GET /orgs/org": {status: http.StatusNotFound},
+				"GET /user":     {status: http.StatusOK, body: map[string]string{"login": "bot"}},
+			},
+		},
+	}
+	for _, test := range otherUserTests {
+		server := newFakeForge(t, test.responses)
+		if err := newTestForge(t, test.provider, server).CreateRepo("org", opts); err == nil {
+			t.Errorf("%s CreateRepo() under another user: expected an error", test.name)
+		}
+	}
+}
+
+func TestOpenPullRequest(t *testing.T) {
+	pr := PullRequest{Title: "Update generated protobuf code to v1.0.0", Body: "Changed packages", Head: "protodist/v1.0.0", Base: "main"}
+
+	// Open pull requests of other branches fill the first page of Gitea pull requests
+	var otherPulls []map[string]interface{}
+	for i := 0; i < giteaPageLimit; i++ {
+		otherPulls = append(otherPulls, map[string]interface{}{"number": 100 + i, "head": map[string]string{"ref": "feature"}, "base": map[string]string{"ref": "main"}})
+	}
+
+	tests := []struct {
+		name      string
+		provider  Provider
+		responses map[string]fakeResponse
+		method    string
+		endpoint  string
+		want      map[string]interface{}
+		wantURL   string
+	}{
+		{
+			name:     "github open",
+			provider: GitHub{},
+			responses: map[string]fakeResponse{
+				"GET /repos/org/proto-foo-go/pulls?base=main&head=org%3Aprotodist%2Fv1.0.0&state=open": {status: http.StatusOK, body: []interface{}{}},
+				"POST /repos/org/proto-foo-go/pulls":                                                   {status: http.StatusCreated, body: map[string]interface{}{"number": 1, "html_url": "https://github.com/org/proto-foo-go/pull/1"}},
+			},
+			method:   http.MethodPost,
+			endpoint: "/repos/org/proto-foo-go/pulls",
+			want:     map[string]interface{}{"title": pr.Title, "body": pr.Body, "head": pr.Head, "base": pr.Base},
+			wantURL:  "https://github.com/org/proto-foo-go/pull/1",
+		},
+		{
+			name:     "github update",
+			provider: GitHub{},
+			responses: map[string]fakeResponse{
+				"GET /repos/org/proto-foo-go/pulls?base=main&head=org%3Aprotodist%2Fv1.0.0&state=open": {status: http.StatusOK, body: []interface{}{
+					map[string]interface{}{"number": 7, "html_url": "https://github.com/org/proto-foo-go/pull/7"},
+				}},
+				"PATCH /repos/org/proto-foo-go/pulls/7": {status: http.StatusOK},
+			},
+			method:   http.MethodPatch,
+			endpoint: "/repos/org/proto-foo-go/pulls/7",
+			want:     map[string]interface{}{"title": pr.Title, "body": pr.Body},
+			wantURL:  "https://github.com/org/proto-foo-go/pull/7",
+		},
+		{
+			name:     "gitlab open",
+			provider: GitLab{},
+			responses: map[string]fakeResponse{
+				"GET /projects/org%2Fproto-foo-go/merge_requests?source_branch=protodist%2Fv1.0.0&state=opened&target_branch=main": {status: http.StatusOK, body: []interface{}{}},
+				"POST /projects/org%2Fproto-foo-go/merge_requests":                                                                 {status: http.StatusCreated, body: map[string]interface{}{"iid": 1, "web_url": "https://gitlab.com/org/proto-foo-go/-/merge_requests/1"}},
+			},
+			method:   http.MethodPost,
+			endpoint: "/projects/org%2Fproto-foo-go/merge_requests",
+			want: map[string]interface{}{
+				"title": pr.Title, "description": pr.Body, "source_branch": pr.Head, "target_branch": pr.Base, "remove_source_branch": true,
+			},
+			wantURL: "https://gitlab.com/org/proto-foo-go/-/merge_requests/1",
+		},
+		{
+			name:     "gitlab update",
+			provider: GitLab{},
+			responses: map[string]fakeResponse{
+				"GET /projects/org%2Fproto-foo-go/merge_requests?source_branch=protodist%2Fv1.0.0&state=opened&target_branch=main": {status: http.StatusOK, body: []interface{}{
+					map[string]interface{}{"iid": 7, "web_url": "https://gitlab.com/org/proto-foo-go/-/merge_requests/7"},
+				}},
+				"PUT /projects/org%2Fproto-foo-go/merge_requests/7": {status: http.StatusOK},
+			},
+			method:   http.MethodPut,
+			endpoint: "/projects/org%2Fproto-foo-go/merge_requests/7",
+			want:     map[string]interface{}{"title": pr.Title, "description": pr.Body},
+			wantURL:  "https://gitlab.com/org/proto-foo-go/-/merge_requests/7",
+		},
+		{
+			name:     "gitea open",
+			provider: Gitea{},
+			responses: map[string]fakeResponse{
+				"GET /repos/org/proto-foo-go/pulls?state=open&page=1&limit=50": {status: http.StatusOK, body: otherPulls},
+				"GET /repos/org/proto-foo-go/pulls?state=open&page=2&limit=50": {status: http.StatusOK, body: []interface{}{}},
+				"POST /repos/org/proto-foo-go/pulls":                           {status: http.StatusCreated, body: map[string]interface{}{"number": 1, "html_url": "https://gitea.com/org/proto-foo-go/pulls/1"}},
+			},
+			method:   http.MethodPost,
+			endpoint: "/repos/org/proto-foo-go/pulls",
+			want:     map[string]interface{}{"title": pr.Title, "body": pr.Body, "head": pr.Head, "base": pr.Base},
+			wantURL:  "https://gitea.com/org/proto-foo-go/pulls/1",
+		},
+		{
+			name:     "gitea update on second page",
+			provider: Gitea{},
+			responses: map[string]fakeResponse{
+				"GET /repos/org/proto-foo-go/pulls?state=open&page=1&limit=50": {status: http.StatusOK, body: otherPulls},
+				"GET /repos/org/proto-foo-go/pulls?state=open&page=2&limit=50": {status: http.StatusOK, body: []interface{}{
+					map[string]interface{}{"number": 7, "html_url": "https://gitea.com/org/proto-foo-go/pulls/7", "head": map[string]string{"ref": pr.Head}, "base": map[string]string{"ref": pr.Base}},
+				}},
+				"PATCH /repos/org/proto-foo-go/pulls/7": {status: http.StatusOK},
+			},
+			method:   http.MethodPatch,
+			endpoint: "/repos/org/proto-foo-go/pulls/7",
+			want:     map[string]interface{}{"title": pr.Title, "body": pr.Body},
+			wantURL:  "https://gitea.com/org/proto-foo-go/pulls/7",
+		},
+	}
+
+	for _, test := range tests {
+		server := newFakeForge(t, test.responses)
+		pullRequestURL, err := newTestForge(t, test.provider, server).OpenPullRequest("org", "proto-foo-go", pr)
+		if err != nil {
+			t.Errorf("%s OpenPullRequest(): %s", test.name, err)
+			continue
+		}
+		if pullRequestURL != test.wantURL {
+			t.Errorf("%s OpenPullRequest() = %s, want %s", test.name, pullRequestURL, test.wantURL)
+		}
+		if got := server.request(t, test.method, test.endpoint).Body; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s OpenPullRequest() payload = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// Auto-merge is enabled on a reused pull request
+	server := newFakeForge(t, map[string]fakeResponse{
+		"GET /projects/org%2Fproto-foo-go/merge_requests?source_branch=protodist%2Fv1.0.0&state=opened&target_branch=main": {status: http.StatusOK, body: []interface{}{
+			map[string]interface{}{"iid": 7, "web_url": "https://gitlab.com/org/proto-foo-go/-/merge_requests/7"},
+		}},
+		"PUT /projects/org%2Fproto-foo-go/merge_requests/7":       {status: http.StatusOK},
+		"PUT /projects/org%2Fproto-foo-go/merge_requests/7/merge": {status: http.StatusOK},
+	})
+	autoMerge := pr
+	autoMerge.AutoMerge = true
+	if _, err := newTestForge(t, GitLab{}, server).OpenPullRequest("org", "proto-foo-go", autoMerge); err != nil {
+		t.Fatal(err)
+	}
+	if body := server.request(t, http.MethodPut, "/projects/org%2Fproto-foo-go/merge_requests/7/merge").Body; body["merge_when_pipeline_succeeds"] != true {
+		t.Errorf("OpenPullRequest() auto-merge payload = %v", body)
+	}
+}
@@ -13,23 +13,53 @@ import (
 
 // Config
 type Config struct {
-	Owner string
-	Host  string
-	Ref   string
-	Token string
+	Owner    string
+	Host     string
+	Ref      string
+	Token    string
+	Provider Provider
+
+	// SSH private key and known_hosts file, system defaults are used when empty
+	SSHKey     string
+	KnownHosts string
+
+	// Missing repos are created through Forge API with NewRepo options
+	CreateMissingRepos bool
+	Forge              Forge
+	NewRepo            RepoOptions
+
+	// Publish mode (push or pr), pull requests are opened against PullRequestBase branch
+	Publish         string
+	PullRequestBase string
+	AutoMerge       bool
+
+	// Clones are shallow when CloneDepth is set, MirrorDir keeps bare mirrors of cloned repos between runs
+	CloneDepth int
+	MirrorDir  string
+	// Monorepo clones are blobless and sparse when SparseCheckout is set, only SparseDirs (language dirs of distributed
+	// targets) and files in the repo root are checked out
+	SparseCheckout bool
+	SparseDirs     []string
+
+	// All packages are distributed through a single Monorepo, instead of a repo per package and language
+	Monorepo string
+
+	// Release versions, keyed by path.Join(repo, dir) of a released repo dir (see PackageRepo)
+	Versions map[string]string
 }
 
-func NewConfig(repoOwner, host, ref, token string) (Config, error) {
+func NewConfig(repoOwner, host, ref, token string, provider Provider) (Config, error) {
 	// Check if ref type is correct
 	if !(strings.HasPrefix(ref, "refs/heads") || strings.HasPrefix(ref, "refs/tags")) {
 		return Config{}, errors.New("git ref should be in format of refs/heads/* or refs/tags/* ")
 	}
 
 	return Config{
-		Owner: repoOwner,
-		Host:  host,
-		Ref:   ref,
-		Token: token,
+		Owner:    repoOwner,
+		Host:     host,
+		Ref:      ref,
+		Token:    token,
+		Provider: provider,
 	}, nil
 }
 
@@ -66,30 +96,73 @@ func (c Config) ParseRef() (RefType, string) {
 
 // Resolve repo URL from repo name
 func (c Config) GetRepoURL(repoName string) string {
-	if len(c.Token) > 0 {
-		return fmt.Sprintf("https://%s:x-oauth-basic@%s/%s/%s.git", c.Token, c.Host, c.Owner, repoName)
+	return c.Provider.RepoURL(c.Host, c.Owner, repoName, len(c.Token) > 0)
+}
+
+// PackageRepo returns the repo through which a package of given language is distributed,
+// and a directory within the repo where package files reside.
+// In monorepo layout, all packages reside in <lang>/<pkg> directories of a single repo.
+func (c Config) PackageRepo(lang string, pkg string) (string, string) {
+	if c.Monorepo != "" {
+		return c.Monorepo, path.Join(lang, pkg)
 	}
 
-	transport := "git@" + c.Host
-	return fmt.Sprintf("%s:%s", transport, path.Join(c.Owner, repoName))
+	return fmt.Sprintf("proto-%s-%s", pkg, lang), ""
+}
+
+// Release returns config for publishing a repo dir, Ref is set to the release version tag if there is one
+func (c Config) Release(repo string, dir string) Config {
+	if version, ok := c.Versions[path.Join(repo, dir)]; ok {
+		c.Ref = "refs/tags/" + version
+	}
+
+	return c
+}
+
+// PackageURL returns HTTPS URL of a repo, as it is referenced by package managers of consumers
+func (c Config) PackageURL(repoName string) string {
+	return c.Provider.RepoURL(c.Host, c.Owner, repoName, true)
 }
 
 func (c Config) GitBase() string {
 	return path.Join(c.Host, c.Owner)
 }
 
-// Clone
-func Clone(repoUrl, branch string) {
-	repoUrlFragments := strings.Split(repoUrl, "/")
-	repoName := repoUrlFragments[len(repoUrlFragments)-1]
-	repoName = strings.TrimSuffix(repoName, ".git")
+// Clone a repo into temp dir and check out the branch.
+// Clone is shallow when cfg.CloneDepth is set, and borrows objects from a local mirror when cfg.MirrorDir is set.
+// Monorepo clone is sparse when cfg.SparseDirs are set, blobs of other dirs are fetched only when they are needed.
+func Clone(cfg Config, repoName, branch string) {
+	repoUrl := cfg.GetRepoURL(repoName)
+	sparse := repoName == cfg.Monorepo && len(cfg.SparseDirs) > 0
+
+	args := []string{"clone"}
+	if cfg.CloneDepth > 0 {
+		args = append(args, "--depth", strconv.Itoa(cfg.CloneDepth))
+	}
+	if cfg.MirrorDir != "" {
+		args = append(args, "--reference", updateMirror(cfg, repoName))
+	}
+	if sparse {
+		args = append(args, "--filter=blob:none", "--sparse")
+	}
+	args = append(args, repoUrl, repoName)
 
 	// Clone
-	cmd := exec.Command("git", "clone", repoUrl)
+	cmd := gitCommand(args...)
 	cmd.Dir = os.TempDir()
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = stderr
 	if err := cmd.Run(); err != nil {
-		panic(fmt.Errorf("failed to clone: %s: %s", repoUrl, err))
+		panic(fmt.Errorf("failed to clone: %s: %s", Scrub(repoUrl), err))
+	}
+
+	// Sparse clone checks out only files in the repo root, distributed dirs are added to them
+	if sparse {
+		cmd = gitCommand(append([]string{"sparse-checkout", "set"}, cfg.SparseDirs...)...)
+		cmd.Dir = path.Join(os.TempDir(), repoName)
+		cmd.Stderr = stderr
+		if err := cmd.Run(); err != nil {
+			panic(fmt.Errorf("failed to set sparse checkout dirs of %s: %s", repoName, err))
+		}
 	}
 
 	// If target branch is master, we can skip git switch.
@@ -98,14 +171,37 @@ func Clone(repoUrl, branch string) {
 	}
 
 	// Switch to non-master branch
-	cmd = exec.Command("git", "checkout", "-B", branch)
+	cmd = gitCommand("checkout", "-B", branch)
 	cmd.Dir = path.Join(os.TempDir(), repoName)
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = stderr
 	if err := cmd.Run(); err != nil {
 		panic(fmt.Errorf("failed to checkout branch %s: %s", branch, err))
 	}
 }
 
+// updateMirror creates or fetches a bare mirror of the repo in cfg.MirrorDir, mirror path is returned.
+// Mirrors are kept between runs, so that only new objects are fetched from the remote.
+func updateMirror(cfg Config, repoName string) string {
+	repoUrl := cfg.GetRepoURL(repoName)
+	mirrorDir := path.Join(cfg.MirrorDir, cfg.Host, cfg.Owner, repoName+".git")
+
+	var cmd *exec.Cmd
+	if _, err := os.Stat(mirrorDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(path.Dir(mirrorDir), 0755); err != nil {
+			panic(fmt.Errorf("failed to create mirror dir: %s", err))
+		}
+		cmd = gitCommand("clone", "--mirror", repoUrl, mirrorDir)
+	} else {
+		cmd = gitCommand("--git-dir", mirrorDir, "fetch", "--prune", "origin")
+	}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		panic(fmt.Errorf("failed to update mirror: %s: %s", Scrub(repoUrl), err))
+	}
+
+	return mirrorDir
+}
+
 func AddAll(repoName string) {
 	repoDir := path.Join(os.TempDir(), repoName)
 	_, err := os.Stat(repoDir)
@@ -113,9 +209,9 @@ func AddAll(repoName string) {
 		panic(fmt.Errorf("failed to stat repo dir: %s: %s", repoDir, err))
 	}
 
-	cmd := exec.Command("git", "add", ".")
+	cmd := gitCommand("add", ".")
 	cmd.Dir = repoDir
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = stderr
 	if err := cmd.Run(); err != nil {
 		panic(fmt.Errorf("failed to add to staging: %s: %s", repoName, err))
 	}
@@ -123,9 +219,9 @@ func AddAll(repoName string) {
 
 func Tag(repoName string, tag string) {
 	repoDir := path.Join(os.TempDir(), repoName)
-	cmd := exec.Command("git", "tag", tag)
+	cmd := gitCommand("tag", tag)
 	cmd.Dir = repoDir
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = stderr
 	if err := cmd.Run(); err != nil {
 		panic(fmt.Errorf("failed to tag: %s: %s", repoName, err))
 	}
@@ -138,25 +234,28 @@ func Commit(repoName string, message string) CommitInfo {
 		panic(fmt.Errorf("failed to stat repo dir: %s: %s", repoDir, err))
 	}
 
-	cmd := exec.Command("git", "commit", "-m", message)
+	cmd := gitCommand("commit", "-m", message)
 	cmd.Dir = repoDir
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
+	cmd.Stderr = stderr
+	cmd.Stdout = stdout
 	if err := cmd.Run(); err != nil {
 		panic(fmt.Errorf("failed to commit: %s: %s", repoName, err))
 	}
 
-	cmd = exec.Command("git", "log", "-1", `--format="%at-%h"`, `--abbrev=12`)
+	return LastCommit(repoName)
+}
+
+// LastCommit returns info of the commit checked out in a cloned repo
+func LastCommit(repoName string) CommitInfo {
+	repoDir := path.Join(os.TempDir(), repoName)
+	cmd := gitCommand("log", "-1", `--format="%at-%h"`, `--abbrev=12`)
 	cmd.Dir = repoDir
-	cmd.Stderr = os.Stderr
-	//if err := cmd.Run(); err != nil {
-	//	panic(fmt.Errorf("failed to get last git info: %s: %s", repoName, err))
-	//}
+	cmd.Stderr = stderr
 	out, err := cmd.Output()
 	if err != nil {
 		panic(err)
 	}
-	commitLog := strings.ReplaceAll(string(out), ":", "")
+	commitLog := strings.ReplaceAll(strings.TrimSpace(string(out)), ":", "")
 	commitLog = strings.ReplaceAll(commitLog, `"`, "")
 	commitData := strings.Split(commitLog, "-")
 	unix, err := strconv.ParseInt(commitData[0], 10, 64)
@@ -169,6 +268,23 @@ func Commit(repoName string, message string) CommitInfo {
 	}
 }
 
+// HasStagedChanges reports whether there is anything to commit in a cloned repo
+func HasStagedChanges(repoName string) bool {
+	repoDir := path.Join(os.TempDir(), repoName)
+	cmd := gitCommand("diff", "--cached", "--quiet")
+	cmd.Dir = repoDir
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return true
+	}
+	if err != nil {
+		panic(fmt.Errorf("failed to diff staged changes: %s: %s", repoName, err))
+	}
+
+	return false
+}
+
 func Push(repoName string, branch string) {
 	repoDir := path.Join(os.TempDir(), repoName)
 	_, err := os.Stat(repoDir)
@@ -176,9 +292,9 @@ func Push(repoName string, branch string) {
 		panic(fmt.Errorf("failed to stat repo dir: %s: %s", repoDir, err))
 	}
 
-	cmd := exec.Command("git", "push", "--force", "--tags", "--set-upstream", "origin", branch)
+	cmd := gitCommand("push", "--force", "--tags", "--set-upstream", "origin", branch)
 	cmd.Dir = repoDir
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = stderr
 	if err := cmd.Run(); err != nil {
 		panic(fmt.Errorf("failed to push: %s: %s", repoName, err))
 	}
@@ -0,0 +1,58 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+)
+
+func runGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %s: %s", args, err, out)
+	}
+}
+
+func TestCloneSparse(t *testing.T) {
+	remoteDir := t.TempDir()
+	repoDir := path.Join(remoteDir, "proto-mono")
+	files := []string{"README.md", "go/foo/foo.pb.go", "js/foo/foo_pb.ts", "c/foo/foo.pb.h"}
+	for _, name := range files {
+		if err := os.MkdirAll(path.Dir(path.Join(repoDir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(repoDir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGit(t, repoDir, "init", "-q")
+	runGit(t, repoDir, "symbolic-ref", "HEAD", "refs/heads/master")
+	runGit(t, repoDir, "config", "uploadpack.allowFilter", "true")
+	runGit(t, repoDir, "add", ".")
+	runGit(t, repoDir, "commit", "-q", "-m", "initial")
+
+	// Repos are cloned into temp dir
+	tempDir := os.Getenv("TMPDIR")
+	if err := os.Setenv("TMPDIR", t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Setenv("TMPDIR", tempDir) })
+
+	provider, err := NewURLTemplate("file://{{ .Owner }}/{{ .Repo }}", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{Owner: remoteDir, Provider: provider, Monorepo: "proto-mono", SparseDirs: []string{"go", "js"}}
+	Clone(cfg, "proto-mono", "master")
+
+	checkedOut := map[string]bool{"README.md": true, "go/foo/foo.pb.go": true, "js/foo/foo_pb.ts": true, "c/foo/foo.pb.h": false}
+	for name, want := range checkedOut {
+		_, err := os.Stat(path.Join(os.TempDir(), "proto-mono", name))
+		if got := err == nil; got != want {
+			t.Errorf("%s checked out = %t, want %t", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"text/template"
+)
+
+// Provider knows how to build remote URLs and credentials for a git forge
+type Provider interface {
+	// RepoURL returns the remote URL of a repo, credentials are never part of it.
+	// When https is false, an SSH URL is returned.
+	RepoURL(host, owner, repo string, https bool) string
+	// Credentials returns the HTTPS username and password that authenticate a token.
+	Credentials(token string) (username, password string)
+}
+
+var providers = map[string]Provider{
+	"github":    GitHub{},
+	"gitlab":    GitLab{},
+	"gitea":     Gitea{},
+	"bitbucket": Bitbucket{},
+}
+
+// NewProvider returns a provider by its name (github, gitlab, gitea, bitbucket).
+// If urlTemplate is set, a URLTemplate provider is returned instead.
+func NewProvider(name string, urlTemplate string, username string) (Provider, error) {
+	if urlTemplate != "" {
+		return NewURLTemplate(urlTemplate, username)
+	}
+
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown git provider: %s", name)
+	}
+
+	return provider, nil
+}
+
+func repoURL(host, owner, repo string, https bool) string {
+	if https {
+		return fmt.Sprintf("https://%s/%s.git", host, path.Join(owner, repo))
+	}
+
+	transport := "git@" + host
+	return fmt.Sprintf("%s:%s", transport, path.Join(owner, repo))
+}
+
+// GitHub
+type GitHub struct{}
+
+func (GitHub) RepoURL(host, owner, repo string, https bool) string {
+	return repoURL(host, owner, repo, https)
+}
+
+func (GitHub) Credentials(token string) (string, string) {
+	return token, "x-oauth-basic"
+}
+
+// GitLab, owner can be a group path with nested subgroups (e.g. group/subgroup)
+type GitLab struct{}
+
+func (GitLab) RepoURL(host, owner, repo string, https bool) string {
+	return repoURL(host, owner, repo, https)
+}
+
+func (GitLab) Credentials(token string) (string, string) {
+	return "oauth2", token
+}
+
+// Gitea
+type Gitea struct{}
+
+func (Gitea) RepoURL(host, owner, repo string, https bool) string {
+	return repoURL(host, owner, repo, https)
+}
+
+func (Gitea) Credentials(token string) (string, string) {
+	return token, "x-oauth-basic"
+}
+
+// Bitbucket
+type Bitbucket struct{}
+
+func (Bitbucket) RepoURL(host, owner, repo string, https bool) string {
+	return repoURL(host, owner, repo, https)
+}
+
+func (Bitbucket) Credentials(token string) (string, string) {
+	return "x-token-auth", token
+}
+
+// URLTemplate builds repo URLs from a user defined template, for self-hosted setups.
+// Template has access to .Host, .Owner and .Repo fields, e.g.
+//
+//	https://{{ .Host }}/scm/{{ .Owner }}/{{ .Repo }}.git
+//
+// The token is sent as a password for Username, or as a username when Username is empty.
+type URLTemplate struct {
+	Username string
+	tmpl     *template.Template
+}
+
+func NewURLTemplate(urlTemplate string, username string) (URLTemplate, error) {
+	tmpl, err := template.New("url").Parse(urlTemplate)
+	if err != nil {
+		return URLTemplate{}, fmt.Errorf("failed to parse git url template: %s", err)
+	}
+
+	// Template is executed once with sample data, so that errors (e.g unknown fields) surface before it is used
+	t := URLTemplate{Username: username, tmpl: tmpl}
+	if _, err := t.execute("host", "owner", "repo"); err != nil {
+		return URLTemplate{}, err
+	}
+
+	return t, nil
+}
+
+func (t URLTemplate) RepoURL(host, owner, repo string, https bool) string {
+	url, err := t.execute(host, owner, repo)
+	if err != nil {
+		panic(err)
+	}
+
+	return url
+}
+
+func (t URLTemplate) execute(host, owner, repo string) (string, error) {
+	data := struct {
+		Host  string
+		Owner string
+		Repo  string
+	}{Host: host, Owner: owner, Repo: repo}
+
+	buffer := bytes.NewBuffer(nil)
+	if err := t.tmpl.Execute(buffer, data); err != nil {
+		return "", fmt.Errorf("failed to execute git url template: %s", err)
+	}
+
+	return buffer.String(), nil
+}
+
+func (t URLTemplate) Credentials(token string) (string, string) {
+	if t.Username == "" {
+		return token, "x-oauth-basic"
+	}
+
+	return t.Username, token
+}
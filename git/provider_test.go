@@ -0,0 +1,125 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProviderRepoURL(t *testing.T) {
+	tests := []struct {
+		provider string
+		host     string
+		owner    string
+		https    bool
+		want     string
+	}{
+		{"github", "github.com", "org", true, "https://github.com/org/proto-foo-go.git"},
+		{"github", "github.com", "org", false, "git@github.com:org/proto-foo-go"},
+		{"gitlab", "gitlab.com", "group", true, "https://gitlab.com/group/proto-foo-go.git"},
+		{"gitlab", "gitlab.com", "group", false, "git@gitlab.com:group/proto-foo-go"},
+		{"gitlab", "gitlab.com", "group/sub/subsub", true, "https://gitlab.com/group/sub/subsub/proto-foo-go.git"},
+		{"gitlab", "gitlab.com", "group/sub/subsub", false, "git@gitlab.com:group/sub/subsub/proto-foo-go"},
+		{"gitea", "git.example.com", "org", true, "https://git.example.com/org/proto-foo-go.git"},
+		{"gitea", "git.example.com", "org", false, "git@git.example.com:org/proto-foo-go"},
+		{"bitbucket", "bitbucket.org", "team", true, "https://bitbucket.org/team/proto-foo-go.git"},
+		{"bitbucket", "bitbucket.org", "team", false, "git@bitbucket.org:team/proto-foo-go"},
+	}
+
+	for _, test := range tests {
+		provider, err := NewProvider(test.provider, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := provider.RepoURL(test.host, test.owner, "proto-foo-go", test.https); got != test.want {
+			t.Errorf("%s RepoURL(%s, %s, https=%t) = %s, want %s", test.provider, test.host, test.owner, test.https, got, test.want)
+		}
+	}
+}
+
+func TestProviderCredentials(t *testing.T) {
+	tests := []struct {
+		provider     string
+		wantUsername string
+		wantPassword string
+	}{
+		{"github", "token", "x-oauth-basic"},
+		{"gitlab", "oauth2", "token"},
+		{"gitea", "token", "x-oauth-basic"},
+		{"bitbucket", "x-token-auth", "token"},
+	}
+
+	for _, test := range tests {
+		provider, err := NewProvider(test.provider, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		username, password := provider.Credentials("token")
+		if username != test.wantUsername || password != test.wantPassword {
+			t.Errorf("%s Credentials() = %s:%s, want %s:%s", test.provider, username, password, test.wantUsername, test.wantPassword)
+		}
+	}
+}
+
+func TestUnknownProvider(t *testing.T) {
+	if _, err := NewProvider("sourceforge", "", ""); err == nil {
+		t.Error("expected an error for unknown provider")
+	}
+}
+
+func TestURLTemplate(t *testing.T) {
+	tests := []struct {
+		template     string
+		username     string
+		want         string
+		wantUsername string
+		wantPassword string
+	}{
+		{
+			template:     "https://{{ .Host }}/scm/{{ .Owner }}/{{ .Repo }}.git",
+			want:         "https://git.example.com/scm/org/proto-foo-go.git",
+			wantUsername: "token",
+			wantPassword: "x-oauth-basic",
+		},
+		{
+			template:     "ssh://git@{{ .Host }}:7999/{{ .Owner }}/{{ .Repo }}.git",
+			username:     "ci",
+			want:         "ssh://git@git.example.com:7999/org/proto-foo-go.git",
+			wantUsername: "ci",
+			wantPassword: "token",
+		},
+	}
+
+	for _, test := range tests {
+		provider, err := NewProvider("github", test.template, test.username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := provider.(URLTemplate); !ok {
+			t.Errorf("expected URLTemplate provider for %s, got %T", test.template, provider)
+		}
+		if got := provider.RepoURL("git.example.com", "org", "proto-foo-go", true); got != test.want {
+			t.Errorf("RepoURL() of %s = %s, want %s", test.template, got, test.want)
+		}
+		username, password := provider.Credentials("token")
+		if username != test.wantUsername || password != test.wantPassword {
+			t.Errorf("Credentials() of %s = %s:%s, want %s:%s", test.template, username, password, test.wantUsername, test.wantPassword)
+		}
+	}
+}
+
+func TestURLTemplateErrors(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  string
+	}{
+		{"https://{{ .Host }/{{ .Repo }}.git", "failed to parse git url template"},
+		{"https://{{ .Host }}/{{ .Project }}/{{ .Repo }}.git", "failed to execute git url template"},
+	}
+
+	for _, test := range tests {
+		_, err := NewURLTemplate(test.template, "")
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("NewURLTemplate(%s) error = %v, want %s", test.template, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Publish modes
+const (
+	PublishPush        = "push"
+	PublishPullRequest = "pr"
+)
+
+// Publish pushes commits and tags of a cloned repo to the remote.
+// In pull request mode, commits are pushed to the protodist/<ref name> branch,
+// and a pull request listing the changed packages is opened against cfg.PullRequestBase.
+// Tags aren't pushed in pull request mode, since they would point to commits which aren't merged yet.
+// Pull request is skipped when there is nothing to merge into the base branch.
+func Publish(cfg Config, repoName string, packages []string) {
+	refType, refName := cfg.ParseRef()
+	if cfg.Publish != PublishPullRequest {
+		Push(repoName, refName)
+		return
+	}
+
+	if !HasUnmergedCommits(repoName, cfg.PullRequestBase) {
+		fmt.Printf("%s has no changes, no pull request is opened\n", repoName)
+		return
+	}
+
+	branch := "protodist/" + refName
+	PushHead(repoName, branch)
+	if refType == TagRef {
+		fmt.Printf("warning: tag %s of %s isn't pushed in pull request mode, tag the merge commit once the pull request is merged\n", refName, repoName)
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Generated by protodist from `%s`.\n\nChanged packages:\n", cfg.Ref)
+	for _, pkg := range packages {
+		fmt.Fprintf(&body, "- %s\n", pkg)
+	}
+
+	pullRequestURL, err := cfg.Forge.OpenPullRequest(cfg.Owner, repoName, PullRequest{
+		Title:     fmt.Sprintf("Update generated protobuf code to %s", refName),
+		Body:      body.String(),
+		Head:      branch,
+		Base:      cfg.PullRequestBase,
+		AutoMerge: cfg.AutoMerge,
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to open pull request: %s: %s", repoName, err))
+	}
+
+	fmt.Printf("pull request for %s: %s\n", repoName, pullRequestURL)
+}
+
+// PushHead force pushes HEAD to a remote branch, tags are left out
+func PushHead(repoName string, branch string) {
+	repoDir := path.Join(os.TempDir(), repoName)
+	_, err := os.Stat(repoDir)
+	if err != nil {
+		panic(fmt.Errorf("failed to stat repo dir: %s: %s", repoDir, err))
+	}
+
+	cmd := gitCommand("push", "--force", "origin", "HEAD:refs/heads/"+branch)
+	cmd.Dir = repoDir
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		panic(fmt.Errorf("failed to push: %s: %s", repoName, err))
+	}
+}
+
+// HasUnmergedCommits reports whether HEAD of a cloned repo has commits which the remote branch doesn't have.
+// All commits are unmerged when the remote branch doesn't exist.
+func HasUnmergedCommits(repoName string, branch string) bool {
+	repoDir := path.Join(os.TempDir(), repoName)
+	cmd := gitCommand("rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+branch)
+	cmd.Dir = repoDir
+	if err := cmd.Run(); err != nil {
+		return true
+	}
+
+	cmd = gitCommand("rev-list", "--count", "refs/remotes/origin/"+branch+"..HEAD")
+	cmd.Dir = repoDir
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		panic(fmt.Errorf("failed to list unmerged commits: %s: %s", repoName, err))
+	}
+
+	return strings.TrimSpace(string(out)) != "0"
+}
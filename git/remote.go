@@ -0,0 +1,101 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ErrRepoNotExist is returned by Tags when the remote repo doesn't exist
+var ErrRepoNotExist = errors.New("repo doesn't exist")
+
+// Messages of git remotes, by which a missing repo is told apart from other failures (e.g authentication)
+var repoNotExistMessages = []string{"repository not found", "could not be found", "does not exist", "does not appear to be a git repository"}
+
+// Tags lists tags of a remote repo, without cloning it
+func Tags(cfg Config, repoName string) ([]string, error) {
+	repoUrl := cfg.GetRepoURL(repoName)
+	var errOut bytes.Buffer
+	cmd := gitCommand("ls-remote", "--tags", "--refs", repoUrl)
+	cmd.Stderr = io.MultiWriter(stderr, &errOut)
+	out, err := cmd.Output()
+	if err != nil {
+		if !repoExists(cfg, repoName, errOut.String()) {
+			return nil, ErrRepoNotExist
+		}
+		return nil, fmt.Errorf("failed to list tags: %s: %s", Scrub(repoUrl), err)
+	}
+
+	var tags []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		tags = append(tags, strings.TrimPrefix(fields[1], "refs/tags/"))
+	}
+
+	return tags, nil
+}
+
+// repoExists tells whether a repo, which git failed to reach, exists. Forge API is asked when it is configured,
+// otherwise the error output of git is matched, so that failures other than a missing repo are never taken for one.
+func repoExists(cfg Config, repoName string, errOut string) bool {
+	if cfg.Forge != nil {
+		exists, err := cfg.Forge.RepoExists(cfg.Owner, repoName)
+		return exists || err != nil
+	}
+
+	errOut = strings.ToLower(errOut)
+	for _, message := range repoNotExistMessages {
+		if strings.Contains(errOut, message) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ReadFile reads a file from a remote repo at ref, only the commit of the ref is fetched.
+// os.ErrNotExist is returned when the file doesn't exist at ref.
+func ReadFile(cfg Config, repoName string, ref string, file string) ([]byte, error) {
+	repoUrl := cfg.GetRepoURL(repoName)
+	fetchDir, err := ioutil.TempDir(os.TempDir(), "protodist-fetch-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(fetchDir)
+
+	for _, args := range [][]string{
+		{"init", "--quiet", "--bare"},
+		{"fetch", "--quiet", "--depth", "1", repoUrl, ref},
+	} {
+		cmd := gitCommand(args...)
+		cmd.Dir = fetchDir
+		cmd.Stderr = stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %s: %s", ref, Scrub(repoUrl), err)
+		}
+	}
+
+	object := "FETCH_HEAD:" + file
+	cmd := gitCommand("cat-file", "-e", object)
+	cmd.Dir = fetchDir
+	if err := cmd.Run(); err != nil {
+		return nil, os.ErrNotExist
+	}
+
+	cmd = gitCommand("show", object)
+	cmd.Dir = fetchDir
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s at %s: %s", file, ref, err)
+	}
+
+	return out, nil
+}
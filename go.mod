@@ -9,4 +9,5 @@ require (
 	github.com/spf13/viper v1.7.0
 	github.com/stretchr/testify v1.5.1 // indirect
 	golang.org/x/tools v0.0.0-20191112195655-aa38f8e97acc
+	google.golang.org/protobuf v1.25.0
 )
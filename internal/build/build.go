@@ -0,0 +1,186 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/4nte/protodist/config"
+	"github.com/4nte/protodist/util"
+)
+
+// DescriptorSetFile is written into proto out dir, along with generated code
+const DescriptorSetFile = "descriptor_set.pb"
+
+// Plugin of protoc, output is generated into OutDir of proto out dir, which is a target input (e.g go, ts, c)
+type Plugin struct {
+	Name   string
+	OutDir string
+	Opt    string
+}
+
+// DefaultPlugins generate the layout expected by targets
+var DefaultPlugins = []Plugin{
+	{Name: "go", OutDir: "go", Opt: "paths=source_relative"},
+	{Name: "go-grpc", OutDir: "go", Opt: "paths=source_relative"},
+	{Name: "ts_proto", OutDir: "ts", Opt: "outputServices=grpc-js,esModuleInterop=true"},
+	{Name: "nanopb", OutDir: "c"},
+}
+
+// ParsePlugin parses <name>=<out_dir>[:<opt>], e.g. go=go:paths=source_relative
+func ParsePlugin(s string) (Plugin, error) {
+	nameAndOut := strings.SplitN(s, "=", 2)
+	if len(nameAndOut) != 2 || nameAndOut[0] == "" || nameAndOut[1] == "" {
+		return Plugin{}, fmt.Errorf("plugin should be in format of <name>=<out_dir>[:<opt>], got: %s", s)
+	}
+
+	plugin := Plugin{Name: nameAndOut[0]}
+	outAndOpt := strings.SplitN(nameAndOut[1], ":", 2)
+	plugin.OutDir = outAndOpt[0]
+	if len(outAndOpt) == 2 {
+		plugin.Opt = outAndOpt[1]
+	}
+
+	return plugin, nil
+}
+
+const bufGenTemplate = `version: v1
+plugins:
+{{- range .Plugins }}
+  - name: {{ printf "%q" .Name }}
+    out: {{ printf "%q" (outDir .) }}
+    {{- if .Opt }}
+    opt: {{ printf "%q" .Opt }}
+    {{- end }}
+{{- end }}
+`
+
+// Build compiles protos of cfg.SourceDir into protoOutDir with protoc or buf.
+// Path of the descriptor set of compiled protos is returned.
+func Build(cfg config.Build, plugins []Plugin, protoOutDir string) (string, error) {
+	protoOutDir, err := filepath.Abs(protoOutDir)
+	if err != nil {
+		return "", err
+	}
+	for _, plugin := range plugins {
+		if err := util.CreateIfNotExists(path.Join(protoOutDir, plugin.OutDir), 0755); err != nil {
+			return "", err
+		}
+	}
+	descriptorSetPath := path.Join(protoOutDir, DescriptorSetFile)
+
+	switch cfg.Compiler {
+	case "protoc":
+		err = runProtoc(cfg, plugins, protoOutDir, descriptorSetPath)
+	case "buf":
+		err = runBuf(cfg, plugins, protoOutDir, descriptorSetPath)
+	default:
+		err = fmt.Errorf("unknown proto compiler: %s", cfg.Compiler)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return descriptorSetPath, nil
+}
+
+func runProtoc(cfg config.Build, plugins []Plugin, protoOutDir string, descriptorSetPath string) error {
+	files, err := protoFiles(cfg.SourceDir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no proto files found in %s", cfg.SourceDir)
+	}
+
+	args := []string{"--proto_path=."}
+	for _, include := range cfg.Includes {
+		absInclude, err := filepath.Abs(include)
+		if err != nil {
+			return err
+		}
+		args = append(args, "--proto_path="+absInclude)
+	}
+	for _, plugin := range plugins {
+		args = append(args, fmt.Sprintf("--%s_out=%s", plugin.Name, path.Join(protoOutDir, plugin.OutDir)))
+		if plugin.Opt != "" {
+			args = append(args, fmt.Sprintf("--%s_opt=%s", plugin.Name, plugin.Opt))
+		}
+	}
+	args = append(args, "--include_imports", "--descriptor_set_out="+descriptorSetPath)
+	args = append(args, files...)
+
+	return run(cfg.SourceDir, "protoc", args...)
+}
+
+func runBuf(cfg config.Build, plugins []Plugin, protoOutDir string, descriptorSetPath string) error {
+	tmpl, err := template.New("buf.gen.yaml").Funcs(template.FuncMap{
+		"outDir": func(plugin Plugin) string {
+			return path.Join(protoOutDir, plugin.OutDir)
+		},
+	}).Parse(bufGenTemplate)
+	if err != nil {
+		return err
+	}
+	buffer := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(buffer, struct{ Plugins []Plugin }{plugins}); err != nil {
+		return err
+	}
+
+	templateDir, err := ioutil.TempDir("", "protodist-buf-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(templateDir)
+	templatePath := path.Join(templateDir, "buf.gen.yaml")
+	if err := ioutil.WriteFile(templatePath, buffer.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	if err := run(cfg.SourceDir, "buf", "generate", "--template", templatePath); err != nil {
+		return err
+	}
+
+	return run(cfg.SourceDir, "buf", "build", "--as-file-descriptor-set", "-o", descriptorSetPath)
+}
+
+// protoFiles returns .proto files in dir, relative to dir
+func protoFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(filePath) != ".proto" {
+			return nil
+		}
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(relPath))
+		return nil
+	})
+	sort.Strings(files)
+
+	return files, err
+}
+
+func run(dir string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %s", name, err)
+	}
+
+	return nil
+}
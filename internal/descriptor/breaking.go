@@ -0,0 +1,64 @@
+package descriptor
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Category of compatibility, similar to buf breaking categories.
+// Categories are ordered, a change which breaks the wire encoding breaks JSON encoding and generated code as well.
+type Category int
+
+const (
+	// Package breaks generated code, which is compiled against the package
+	Package Category = iota + 1
+	// WireJSON breaks JSON encoding
+	WireJSON
+	// Wire breaks binary encoding
+	Wire
+)
+
+var categoryNames = map[Category]string{
+	Package:  "package",
+	WireJSON: "wire_json",
+	Wire:     "wire",
+}
+
+func (c Category) String() string {
+	return categoryNames[c]
+}
+
+// ParseCategory parses package, wire_json or wire
+func ParseCategory(s string) (Category, error) {
+	for category, name := range categoryNames {
+		if strings.EqualFold(s, name) {
+			return category, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown breaking change category: %s", s)
+}
+
+// Breaking filters changes which break compatibility of the category
+func Breaking(changes []Change, category Category) []Change {
+	var breakingChanges []Change
+	for _, change := range changes {
+		if change.Category != 0 && change.Category >= category {
+			breakingChanges = append(breakingChanges, change)
+		}
+	}
+
+	return breakingChanges
+}
+
+// Report writes breaking changes of a package as a table
+func Report(w io.Writer, pkg string, category Category, changes []Change) {
+	fmt.Fprintf(w, "package %s has %d %s breaking change(s):\n", pkg, len(changes), category)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, change := range changes {
+		fmt.Fprintf(tw, "\t%s\t%s\t%s\n", change.Element, change.Rule, change.Message)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,73 @@
+package descriptor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Load reads a binary FileDescriptorSet (e.g. protoc --descriptor_set_out or buf build -o)
+func Load(filename string) (*descriptorpb.FileDescriptorSet, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return Unmarshal(data)
+}
+
+func Unmarshal(data []byte) (*descriptorpb.FileDescriptorSet, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal descriptor set: %s", err)
+	}
+
+	return set, nil
+}
+
+func Marshal(set *descriptorpb.FileDescriptorSet) ([]byte, error) {
+	return proto.Marshal(set)
+}
+
+// PackageOf returns the distributed package of a proto file, which is the first directory of its path.
+// Generated code is laid out the same way, e.g. foo/bar.proto is generated into go/foo/bar.pb.go
+func PackageOf(file *descriptorpb.FileDescriptorProto) string {
+	dir := path.Dir(file.GetName())
+	if dir == "." {
+		return ""
+	}
+
+	return strings.Split(dir, "/")[0]
+}
+
+// Packages returns distributed packages of files in a descriptor set
+func Packages(set *descriptorpb.FileDescriptorSet) []string {
+	var packages []string
+	seen := make(map[string]bool)
+	for _, file := range set.GetFile() {
+		pkg := PackageOf(file)
+		if pkg == "" || seen[pkg] {
+			continue
+		}
+		seen[pkg] = true
+		packages = append(packages, pkg)
+	}
+
+	return packages
+}
+
+// PackageSet returns a descriptor set with only the files of a distributed package
+func PackageSet(set *descriptorpb.FileDescriptorSet, pkg string) *descriptorpb.FileDescriptorSet {
+	pkgSet := &descriptorpb.FileDescriptorSet{}
+	for _, file := range set.GetFile() {
+		if PackageOf(file) == pkg {
+			pkgSet.File = append(pkgSet.File, file)
+		}
+	}
+
+	return pkgSet
+}
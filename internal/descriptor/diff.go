@@ -0,0 +1,383 @@
+package descriptor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Bump of a semantic version
+type Bump int
+
+const (
+	Patch Bump = iota
+	Minor
+	Major
+)
+
+func (b Bump) String() string {
+	switch b {
+	case Major:
+		return "major"
+	case Minor:
+		return "minor"
+	}
+
+	return "patch"
+}
+
+// Change between two descriptor sets
+type Change struct {
+	Bump Bump
+	// Fully qualified name of the changed element
+	Element string
+	Message string
+	// Rule and Category are set for breaking changes
+	Rule     string
+	Category Category
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s", c.Element, c.Message)
+}
+
+func breaking(rule string, category Category, element string, message string) Change {
+	return Change{Bump: Major, Element: element, Message: message, Rule: rule, Category: category}
+}
+
+func added(element string, message string) Change {
+	return Change{Bump: Minor, Element: element, Message: message}
+}
+
+// RequiredBump returns the highest bump required by changes, Patch when there are no changes
+func RequiredBump(changes []Change) Bump {
+	bump := Patch
+	for _, change := range changes {
+		if change.Bump > bump {
+			bump = change.Bump
+		}
+	}
+
+	return bump
+}
+
+// Compare returns changes from previous to current descriptor set.
+// Removed or modified elements are breaking (Major), added elements are Minor.
+// Breaking changes are categorized by compatibility they break, see Category.
+func Compare(previous, current *descriptorpb.FileDescriptorSet) []Change {
+	prev, curr := index(previous), index(current)
+	var changes []Change
+
+	for _, name := range sortedKeys(prev.messages) {
+		prevMessage := prev.messages[name]
+		currMessage, ok := curr.messages[name]
+		if !ok {
+			changes = append(changes, breaking("MESSAGE_NO_DELETE", Package, name, "message removed"))
+			continue
+		}
+		changes = append(changes, compareFields(name, prevMessage, currMessage)...)
+	}
+	for _, name := range sortedKeys(curr.messages) {
+		if _, ok := prev.messages[name]; !ok {
+			changes = append(changes, added(name, "message added"))
+		}
+	}
+
+	for _, name := range sortedKeys(prev.enums) {
+		prevEnum := prev.enums[name]
+		currEnum, ok := curr.enums[name]
+		if !ok {
+			changes = append(changes, breaking("ENUM_NO_DELETE", Package, name, "enum removed"))
+			continue
+		}
+		changes = append(changes, compareEnumValues(name, prevEnum, currEnum)...)
+	}
+	for _, name := range sortedKeys(curr.enums) {
+		if _, ok := prev.enums[name]; !ok {
+			changes = append(changes, added(name, "enum added"))
+		}
+	}
+
+	for _, name := range sortedKeys(prev.services) {
+		prevService := prev.services[name]
+		currService, ok := curr.services[name]
+		if !ok {
+			changes = append(changes, breaking("SERVICE_NO_DELETE", Package, name, "service removed"))
+			continue
+		}
+		changes = append(changes, compareMethods(name, prevService, currService)...)
+	}
+	for _, name := range sortedKeys(curr.services) {
+		if _, ok := prev.services[name]; !ok {
+			changes = append(changes, added(name, "service added"))
+		}
+	}
+
+	return changes
+}
+
+func compareFields(messageName string, prev, curr *descriptorpb.DescriptorProto) []Change {
+	var changes []Change
+	currFields := make(map[int32]*descriptorpb.FieldDescriptorProto)
+	for _, field := range curr.GetField() {
+		currFields[field.GetNumber()] = field
+	}
+	prevFields := make(map[int32]*descriptorpb.FieldDescriptorProto)
+	for _, field := range prev.GetField() {
+		prevFields[field.GetNumber()] = field
+	}
+
+	for _, prevField := range prev.GetField() {
+		element := fmt.Sprintf("%s.%s", messageName, prevField.GetName())
+		currField, ok := currFields[prevField.GetNumber()]
+		if !ok {
+			// Removed field is safe on the wire only if its number can't be reused, and in JSON if its name can't be reused
+			message := fmt.Sprintf("field %d removed", prevField.GetNumber())
+			if !isFieldNumberReserved(curr, prevField.GetNumber()) {
+				changes = append(changes, breaking("FIELD_NO_DELETE_UNLESS_NUMBER_RESERVED", Wire, element, message))
+			} else if !isFieldNameReserved(curr, prevField.GetName()) {
+				changes = append(changes, breaking("FIELD_NO_DELETE_UNLESS_NAME_RESERVED", WireJSON, element, message))
+			} else {
+				changes = append(changes, breaking("FIELD_NO_DELETE", Package, element, message))
+			}
+			continue
+		}
+		if prevField.GetJsonName() != currField.GetJsonName() {
+			changes = append(changes, breaking("FIELD_SAME_JSON_NAME", WireJSON, element, fmt.Sprintf("field %d JSON name changed from %s to %s", prevField.GetNumber(), prevField.GetJsonName(), currField.GetJsonName())))
+		} else if prevField.GetName() != currField.GetName() {
+			changes = append(changes, breaking("FIELD_SAME_NAME", Package, element, fmt.Sprintf("field %d renamed to %s", prevField.GetNumber(), currField.GetName())))
+		}
+		// Map fields are compared by key and value fields of their entries, entry names follow field names
+		if prevEntry, currEntry := mapEntry(prev, prevField), mapEntry(curr, currField); prevEntry != nil && currEntry != nil {
+			changes = append(changes, compareFields(element, prevEntry, currEntry)...)
+		} else if prevField.GetType() != currField.GetType() || prevField.GetTypeName() != currField.GetTypeName() {
+			changes = append(changes, breaking("FIELD_SAME_TYPE", Wire, element, fmt.Sprintf("field type changed from %s to %s", fieldType(prevField), fieldType(currField))))
+		}
+		if prevField.GetLabel() != currField.GetLabel() {
+			changes = append(changes, breaking("FIELD_SAME_LABEL", Wire, element, fmt.Sprintf("field label changed from %s to %s", prevField.GetLabel(), currField.GetLabel())))
+		}
+	}
+	for _, currField := range curr.GetField() {
+		if _, ok := prevFields[currField.GetNumber()]; !ok {
+			changes = append(changes, added(fmt.Sprintf("%s.%s", messageName, currField.GetName()), "field added"))
+		}
+	}
+
+	return changes
+}
+
+func compareEnumValues(enumName string, prev, curr *descriptorpb.EnumDescriptorProto) []Change {
+	var changes []Change
+	currValues := make(map[int32]*descriptorpb.EnumValueDescriptorProto)
+	for _, value := range curr.GetValue() {
+		currValues[value.GetNumber()] = value
+	}
+	prevValues := make(map[int32]*descriptorpb.EnumValueDescriptorProto)
+	for _, value := range prev.GetValue() {
+		prevValues[value.GetNumber()] = value
+	}
+
+	for _, prevValue := range prev.GetValue() {
+		element := fmt.Sprintf("%s.%s", enumName, prevValue.GetName())
+		currValue, ok := currValues[prevValue.GetNumber()]
+		if !ok {
+			message := fmt.Sprintf("enum value %d removed", prevValue.GetNumber())
+			if !isEnumNumberReserved(curr, prevValue.GetNumber()) {
+				changes = append(changes, breaking("ENUM_VALUE_NO_DELETE_UNLESS_NUMBER_RESERVED", Wire, element, message))
+			} else if !isEnumNameReserved(curr, prevValue.GetName()) {
+				changes = append(changes, breaking("ENUM_VALUE_NO_DELETE_UNLESS_NAME_RESERVED", WireJSON, element, message))
+			} else {
+				changes = append(changes, breaking("ENUM_VALUE_NO_DELETE", Package, element, message))
+			}
+			continue
+		}
+		// Enum values are encoded by name in JSON
+		if prevValue.GetName() != currValue.GetName() {
+			changes = append(changes, breaking("ENUM_VALUE_SAME_NAME", WireJSON, element, fmt.Sprintf("enum value %d renamed to %s", prevValue.GetNumber(), currValue.GetName())))
+		}
+	}
+	for _, currValue := range curr.GetValue() {
+		if _, ok := prevValues[currValue.GetNumber()]; !ok {
+			changes = append(changes, added(fmt.Sprintf("%s.%s", enumName, currValue.GetName()), "enum value added"))
+		}
+	}
+
+	return changes
+}
+
+// Methods are matched by name, so a renamed RPC is reported as removed
+func compareMethods(serviceName string, prev, curr *descriptorpb.ServiceDescriptorProto) []Change {
+	var changes []Change
+	currMethods := make(map[string]*descriptorpb.MethodDescriptorProto)
+	for _, method := range curr.GetMethod() {
+		currMethods[method.GetName()] = method
+	}
+	prevMethods := make(map[string]*descriptorpb.MethodDescriptorProto)
+	for _, method := range prev.GetMethod() {
+		prevMethods[method.GetName()] = method
+	}
+
+	for _, prevMethod := range prev.GetMethod() {
+		element := fmt.Sprintf("%s.%s", serviceName, prevMethod.GetName())
+		currMethod, ok := currMethods[prevMethod.GetName()]
+		if !ok {
+			changes = append(changes, breaking("RPC_NO_DELETE", Package, element, "rpc removed"))
+			continue
+		}
+		if prevMethod.GetInputType() != currMethod.GetInputType() {
+			changes = append(changes, breaking("RPC_SAME_REQUEST_TYPE", Wire, element, fmt.Sprintf("rpc request changed from %s to %s", prevMethod.GetInputType(), currMethod.GetInputType())))
+		}
+		if prevMethod.GetOutputType() != currMethod.GetOutputType() {
+			changes = append(changes, breaking("RPC_SAME_RESPONSE_TYPE", Wire, element, fmt.Sprintf("rpc response changed from %s to %s", prevMethod.GetOutputType(), currMethod.GetOutputType())))
+		}
+		if prevMethod.GetClientStreaming() != currMethod.GetClientStreaming() || prevMethod.GetServerStreaming() != currMethod.GetServerStreaming() {
+			changes = append(changes, breaking("RPC_SAME_STREAMING", Wire, element, "rpc streaming changed"))
+		}
+	}
+	for _, currMethod := range curr.GetMethod() {
+		if _, ok := prevMethods[currMethod.GetName()]; !ok {
+			changes = append(changes, added(fmt.Sprintf("%s.%s", serviceName, currMethod.GetName()), "rpc added"))
+		}
+	}
+
+	return changes
+}
+
+// mapEntry returns the synthetic map entry message of a map field, nil is returned for other fields
+func mapEntry(message *descriptorpb.DescriptorProto, field *descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+	if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+		return nil
+	}
+	for _, nested := range message.GetNestedType() {
+		if nested.GetOptions().GetMapEntry() && strings.HasSuffix(field.GetTypeName(), "."+nested.GetName()) {
+			return nested
+		}
+	}
+
+	return nil
+}
+
+func isFieldNumberReserved(message *descriptorpb.DescriptorProto, number int32) bool {
+	for _, reserved := range message.GetReservedRange() {
+		// End is exclusive
+		if number >= reserved.GetStart() && number < reserved.GetEnd() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isFieldNameReserved(message *descriptorpb.DescriptorProto, name string) bool {
+	for _, reserved := range message.GetReservedName() {
+		if reserved == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isEnumNumberReserved(enum *descriptorpb.EnumDescriptorProto, number int32) bool {
+	for _, reserved := range enum.GetReservedRange() {
+		// End is inclusive
+		if number >= reserved.GetStart() && number <= reserved.GetEnd() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isEnumNameReserved(enum *descriptorpb.EnumDescriptorProto, name string) bool {
+	for _, reserved := range enum.GetReservedName() {
+		if reserved == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func fieldType(field *descriptorpb.FieldDescriptorProto) string {
+	if field.GetTypeName() != "" {
+		return field.GetTypeName()
+	}
+
+	return field.GetType().String()
+}
+
+// elements of a descriptor set by fully qualified name
+type elements struct {
+	messages map[string]*descriptorpb.DescriptorProto
+	enums    map[string]*descriptorpb.EnumDescriptorProto
+	services map[string]*descriptorpb.ServiceDescriptorProto
+}
+
+func index(set *descriptorpb.FileDescriptorSet) elements {
+	e := elements{
+		messages: make(map[string]*descriptorpb.DescriptorProto),
+		enums:    make(map[string]*descriptorpb.EnumDescriptorProto),
+		services: make(map[string]*descriptorpb.ServiceDescriptorProto),
+	}
+	for _, file := range set.GetFile() {
+		prefix := file.GetPackage()
+		for _, message := range file.GetMessageType() {
+			e.addMessage(prefix, message)
+		}
+		for _, enum := range file.GetEnumType() {
+			e.enums[qualify(prefix, enum.GetName())] = enum
+		}
+		for _, service := range file.GetService() {
+			e.services[qualify(prefix, service.GetName())] = service
+		}
+	}
+
+	return e
+}
+
+func (e elements) addMessage(prefix string, message *descriptorpb.DescriptorProto) {
+	name := qualify(prefix, message.GetName())
+	// Synthetic map entry messages are compared as a part of the map field
+	if message.GetOptions().GetMapEntry() {
+		return
+	}
+	e.messages[name] = message
+	for _, nested := range message.GetNestedType() {
+		e.addMessage(name, nested)
+	}
+	for _, enum := range message.GetEnumType() {
+		e.enums[qualify(name, enum.GetName())] = enum
+	}
+}
+
+func qualify(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+
+	return prefix + "." + name
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]*descriptorpb.DescriptorProto:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*descriptorpb.EnumDescriptorProto:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*descriptorpb.ServiceDescriptorProto:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
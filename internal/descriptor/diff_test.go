@@ -0,0 +1,317 @@
+package descriptor
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func field(name string, number int32, fieldType descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     fieldType.Enum(),
+	}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+func mapEntryMessage(name string, keyType, valueType descriptorpb.FieldDescriptorProto_Type, valueTypeName string) *descriptorpb.DescriptorProto {
+	return &descriptorpb.DescriptorProto{
+		Name:    proto.String(name),
+		Field:   []*descriptorpb.FieldDescriptorProto{field("key", 1, keyType, ""), field("value", 2, valueType, valueTypeName)},
+		Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+	}
+}
+
+// testSet is a descriptor set of test.v1 package, modified by modify
+func testSet(modify func(file *descriptorpb.FileDescriptorProto)) *descriptorpb.FileDescriptorSet {
+	labels := field("labels", 3, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.v1.Foo.LabelsEntry")
+	labels.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test/v1/test.proto"),
+		Package: proto.String("test.v1"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Foo"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+					labels,
+				},
+				NestedType: []*descriptorpb.DescriptorProto{
+					mapEntryMessage("LabelsEntry", descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+				},
+			},
+			{Name: proto.String("Bar")},
+		},
+		EnumType: []*descriptorpb.EnumDescriptorProto{
+			{
+				Name: proto.String("Status"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{
+					{Name: proto.String("STATUS_UNKNOWN"), Number: proto.Int32(0)},
+					{Name: proto.String("STATUS_ACTIVE"), Number: proto.Int32(1)},
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("FooService"),
+				Method: []*descriptorpb.MethodDescriptorProto{
+					{Name: proto.String("GetFoo"), InputType: proto.String(".test.v1.Foo"), OutputType: proto.String(".test.v1.Foo")},
+				},
+			},
+		},
+	}
+	if modify != nil {
+		modify(file)
+	}
+
+	return &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}}
+}
+
+func TestCompare(t *testing.T) {
+	foo := func(file *descriptorpb.FileDescriptorProto) *descriptorpb.DescriptorProto { return file.MessageType[0] }
+	reserve := func(message *descriptorpb.DescriptorProto, number int32) {
+		message.ReservedRange = append(message.ReservedRange, &descriptorpb.DescriptorProto_ReservedRange{Start: proto.Int32(number), End: proto.Int32(number + 1)})
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(file *descriptorpb.FileDescriptorProto)
+		want     []string
+		wantBump Bump
+	}{
+		{
+			name:     "unchanged",
+			wantBump: Patch,
+		},
+		{
+			name: "message added",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				file.MessageType = append(file.MessageType, &descriptorpb.DescriptorProto{Name: proto.String("Baz")})
+			},
+			want:     []string{"minor test.v1.Baz"},
+			wantBump: Minor,
+		},
+		{
+			name:     "message removed",
+			modify:   func(file *descriptorpb.FileDescriptorProto) { file.MessageType = file.MessageType[:1] },
+			want:     []string{"major package test.v1.Bar MESSAGE_NO_DELETE"},
+			wantBump: Major,
+		},
+		{
+			name: "field added",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				foo(file).Field = append(foo(file).Field, field("extra", 4, descriptorpb.FieldDescriptorProto_TYPE_BOOL, ""))
+			},
+			want:     []string{"minor test.v1.Foo.extra"},
+			wantBump: Minor,
+		},
+		{
+			name:     "field removed",
+			modify:   func(file *descriptorpb.FileDescriptorProto) { foo(file).Field = foo(file).Field[1:] },
+			want:     []string{"major wire test.v1.Foo.name FIELD_NO_DELETE_UNLESS_NUMBER_RESERVED"},
+			wantBump: Major,
+		},
+		{
+			name: "field removed with reserved number",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				foo(file).Field = foo(file).Field[1:]
+				reserve(foo(file), 1)
+			},
+			want:     []string{"major wire_json test.v1.Foo.name FIELD_NO_DELETE_UNLESS_NAME_RESERVED"},
+			wantBump: Major,
+		},
+		{
+			name: "field removed with reserved number and name",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				foo(file).Field = foo(file).Field[1:]
+				reserve(foo(file), 1)
+				foo(file).ReservedName = []string{"name"}
+			},
+			want:     []string{"major package test.v1.Foo.name FIELD_NO_DELETE"},
+			wantBump: Major,
+		},
+		{
+			name: "field renamed",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				foo(file).Field[1].Name = proto.String("total")
+				foo(file).Field[1].JsonName = proto.String("total")
+			},
+			want:     []string{"major wire_json test.v1.Foo.count FIELD_SAME_JSON_NAME"},
+			wantBump: Major,
+		},
+		{
+			name: "field type changed",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				foo(file).Field[1].Type = descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+			},
+			want:     []string{"major wire test.v1.Foo.count FIELD_SAME_TYPE"},
+			wantBump: Major,
+		},
+		{
+			name: "field label changed",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				foo(file).Field[1].Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			},
+			want:     []string{"major wire test.v1.Foo.count FIELD_SAME_LABEL"},
+			wantBump: Major,
+		},
+		{
+			name: "map value type changed",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				foo(file).NestedType[0] = mapEntryMessage("LabelsEntry", descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_STRING, "")
+			},
+			want:     []string{"major wire test.v1.Foo.labels.value FIELD_SAME_TYPE"},
+			wantBump: Major,
+		},
+		{
+			name: "map key type changed",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				foo(file).NestedType[0] = mapEntryMessage("LabelsEntry", descriptorpb.FieldDescriptorProto_TYPE_INT64, descriptorpb.FieldDescriptorProto_TYPE_INT32, "")
+			},
+			want:     []string{"major wire test.v1.Foo.labels.key FIELD_SAME_TYPE"},
+			wantBump: Major,
+		},
+		{
+			name: "map value message changed",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				foo(file).NestedType[0] = mapEntryMessage("LabelsEntry", descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.v1.Bar")
+			},
+			want:     []string{"major wire test.v1.Foo.labels.value FIELD_SAME_TYPE"},
+			wantBump: Major,
+		},
+		{
+			name: "map field renamed",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				foo(file).Field[2].Name = proto.String("tags")
+				foo(file).Field[2].JsonName = proto.String("tags")
+				foo(file).Field[2].TypeName = proto.String(".test.v1.Foo.TagsEntry")
+				foo(file).NestedType[0].Name = proto.String("TagsEntry")
+			},
+			want:     []string{"major wire_json test.v1.Foo.labels FIELD_SAME_JSON_NAME"},
+			wantBump: Major,
+		},
+		{
+			name: "map replaced by message",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				foo(file).Field[2].TypeName = proto.String(".test.v1.Bar")
+				foo(file).NestedType = nil
+			},
+			want:     []string{"major wire test.v1.Foo.labels FIELD_SAME_TYPE"},
+			wantBump: Major,
+		},
+		{
+			name: "enum value added",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				enum := file.EnumType[0]
+				enum.Value = append(enum.Value, &descriptorpb.EnumValueDescriptorProto{Name: proto.String("STATUS_DELETED"), Number: proto.Int32(2)})
+			},
+			want:     []string{"minor test.v1.Status.STATUS_DELETED"},
+			wantBump: Minor,
+		},
+		{
+			name:     "enum value removed",
+			modify:   func(file *descriptorpb.FileDescriptorProto) { file.EnumType[0].Value = file.EnumType[0].Value[:1] },
+			want:     []string{"major wire test.v1.Status.STATUS_ACTIVE ENUM_VALUE_NO_DELETE_UNLESS_NUMBER_RESERVED"},
+			wantBump: Major,
+		},
+		{
+			name: "enum value renamed",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				file.EnumType[0].Value[1].Name = proto.String("STATUS_ENABLED")
+			},
+			want:     []string{"major wire_json test.v1.Status.STATUS_ACTIVE ENUM_VALUE_SAME_NAME"},
+			wantBump: Major,
+		},
+		{
+			name:     "enum removed",
+			modify:   func(file *descriptorpb.FileDescriptorProto) { file.EnumType = nil },
+			want:     []string{"major package test.v1.Status ENUM_NO_DELETE"},
+			wantBump: Major,
+		},
+		{
+			name: "method added",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				service := file.Service[0]
+				service.Method = append(service.Method, &descriptorpb.MethodDescriptorProto{Name: proto.String("ListFoos"), InputType: proto.String(".test.v1.Bar"), OutputType: proto.String(".test.v1.Foo")})
+			},
+			want:     []string{"minor test.v1.FooService.ListFoos"},
+			wantBump: Minor,
+		},
+		{
+			name:     "method removed",
+			modify:   func(file *descriptorpb.FileDescriptorProto) { file.Service[0].Method = nil },
+			want:     []string{"major package test.v1.FooService.GetFoo RPC_NO_DELETE"},
+			wantBump: Major,
+		},
+		{
+			name: "method types changed",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				file.Service[0].Method[0].InputType = proto.String(".test.v1.Bar")
+				file.Service[0].Method[0].OutputType = proto.String(".test.v1.Bar")
+			},
+			want: []string{
+				"major wire test.v1.FooService.GetFoo RPC_SAME_REQUEST_TYPE",
+				"major wire test.v1.FooService.GetFoo RPC_SAME_RESPONSE_TYPE",
+			},
+			wantBump: Major,
+		},
+		{
+			name: "method streaming changed",
+			modify: func(file *descriptorpb.FileDescriptorProto) {
+				file.Service[0].Method[0].ServerStreaming = proto.Bool(true)
+			},
+			want:     []string{"major wire test.v1.FooService.GetFoo RPC_SAME_STREAMING"},
+			wantBump: Major,
+		},
+		{
+			name:     "service removed",
+			modify:   func(file *descriptorpb.FileDescriptorProto) { file.Service = nil },
+			want:     []string{"major package test.v1.FooService SERVICE_NO_DELETE"},
+			wantBump: Major,
+		},
+	}
+
+	for _, test := range tests {
+		changes := Compare(testSet(nil), testSet(test.modify))
+		var got []string
+		for _, change := range changes {
+			// Changes which aren't breaking have no category and rule
+			got = append(got, strings.Join(strings.Fields(fmt.Sprintf("%s %s %s %s", change.Bump, change.Category, change.Element, change.Rule)), " "))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Compare() = %v, want %v", test.name, got, test.want)
+		}
+		if bump := RequiredBump(changes); bump != test.wantBump {
+			t.Errorf("%s: RequiredBump() = %s, want %s", test.name, bump, test.wantBump)
+		}
+	}
+}
+
+func TestRequiredBump(t *testing.T) {
+	tests := []struct {
+		changes []Change
+		want    Bump
+	}{
+		{nil, Patch},
+		{[]Change{added("test.v1.Foo.extra", "field added")}, Minor},
+		{[]Change{added("test.v1.Foo.extra", "field added"), breaking("FIELD_SAME_TYPE", Wire, "test.v1.Foo.count", "field type changed")}, Major},
+		{[]Change{breaking("RPC_NO_DELETE", Package, "test.v1.FooService.GetFoo", "rpc removed"), added("test.v1.Baz", "message added")}, Major},
+	}
+
+	for _, test := range tests {
+		if got := RequiredBump(test.changes); got != test.want {
+			t.Errorf("RequiredBump(%v) = %s, want %s", test.changes, got, test.want)
+		}
+	}
+}
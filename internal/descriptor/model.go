@@ -0,0 +1,182 @@
+package descriptor
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Model of distributed packages and their files, built from a descriptor set.
+// Descriptor set may hold imported files (e.g. protoc --include_imports), packages which aren't distributed
+// are left out, and files of well-known types (google/...) are always skipped.
+type Model struct {
+	Packages map[string]*DistributedPackage
+}
+
+// DistributedPackage is distributed as a Go module, C library, etc.
+// It holds proto files which reside in the same top level directory.
+type DistributedPackage struct {
+	Name  string
+	Files []*File
+	// Distributed packages imported by files of the package
+	Dependencies []string
+	// Imported files which are not part of any distributed package (e.g google/protobuf/timestamp.proto)
+	ExternalImports []string
+}
+
+type File struct {
+	Name         string
+	ProtoPackage string
+	// go_package option, without the package name suffix (e.g foo.com/bar;baz is foo.com/bar)
+	GoPackage string
+	Imports   []string
+	Services  int
+}
+
+// NewModel builds the model of distributed packages from a descriptor set. Files of packages for which isDistributed
+// is false are external imports of the model, all packages are distributed when isDistributed is nil.
+func NewModel(set *descriptorpb.FileDescriptorSet, isDistributed func(pkg string) bool) *Model {
+	model := &Model{Packages: make(map[string]*DistributedPackage)}
+	fileToPackage := make(map[string]string)
+
+	for _, fileDescriptor := range set.GetFile() {
+		pkgName := PackageOf(fileDescriptor)
+		if pkgName == "" || strings.HasPrefix(fileDescriptor.GetName(), "google/") {
+			continue
+		}
+		if isDistributed != nil && !isDistributed(pkgName) {
+			continue
+		}
+		fileToPackage[fileDescriptor.GetName()] = pkgName
+
+		pkg, ok := model.Packages[pkgName]
+		if !ok {
+			pkg = &DistributedPackage{Name: pkgName}
+			model.Packages[pkgName] = pkg
+		}
+
+		goPackage := fileDescriptor.GetOptions().GetGoPackage()
+		if i := strings.Index(goPackage, ";"); i >= 0 {
+			goPackage = goPackage[:i]
+		}
+		pkg.Files = append(pkg.Files, &File{
+			Name:         fileDescriptor.GetName(),
+			ProtoPackage: fileDescriptor.GetPackage(),
+			GoPackage:    goPackage,
+			Imports:      fileDescriptor.GetDependency(),
+			Services:     len(fileDescriptor.GetService()),
+		})
+	}
+
+	for _, pkg := range model.Packages {
+		for _, file := range pkg.Files {
+			for _, imported := range file.Imports {
+				importedPkg, ok := fileToPackage[imported]
+				if !ok {
+					pkg.ExternalImports = appendUnique(pkg.ExternalImports, imported)
+					continue
+				}
+				if importedPkg != pkg.Name {
+					pkg.Dependencies = appendUnique(pkg.Dependencies, importedPkg)
+				}
+			}
+		}
+		sort.Strings(pkg.Dependencies)
+		sort.Strings(pkg.ExternalImports)
+	}
+
+	return model
+}
+
+// LoadModel loads a descriptor set and builds the model from it.
+// Packages are distributed when they have generated code in a language dir of proto out dir (e.g go/foo).
+func LoadModel(filename string, protoOutDir string) (*Model, error) {
+	set, err := Load(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewModel(set, HasGeneratedCode(protoOutDir)), nil
+}
+
+// HasGeneratedCode returns a func which reports whether a package has generated code in any language dir of proto out dir
+func HasGeneratedCode(protoOutDir string) func(pkg string) bool {
+	return func(pkg string) bool {
+		langDirs, err := ioutil.ReadDir(protoOutDir)
+		if err != nil {
+			return false
+		}
+		for _, langDir := range langDirs {
+			if isDir(path.Join(protoOutDir, langDir.Name(), pkg)) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// PackagesOf returns sorted names of distributed packages, which have generated code in a language dir of proto out dir
+func (m *Model) PackagesOf(protoOutDir string, lang string) []string {
+	var names []string
+	for _, name := range m.PackageNames() {
+		if isDir(path.Join(protoOutDir, lang, name)) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// PackageNames returns sorted names of distributed packages
+func (m *Model) PackageNames() []string {
+	var names []string
+	for name := range m.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// HasServices reports whether any file of the package defines a service
+func (p *DistributedPackage) HasServices() bool {
+	for _, file := range p.Files {
+		if file.Services > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GoPackages returns distinct go_package options of package files
+func (p *DistributedPackage) GoPackages() []string {
+	var goPackages []string
+	for _, file := range p.Files {
+		if file.GoPackage != "" {
+			goPackages = appendUnique(goPackages, file.GoPackage)
+		}
+	}
+
+	return goPackages
+}
+
+func isDir(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.IsDir()
+}
+
+func appendUnique(list []string, item string) []string {
+	for _, existing := range list {
+		if existing == item {
+			return list
+		}
+	}
+
+	return append(list, item)
+}
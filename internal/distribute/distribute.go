@@ -2,8 +2,12 @@ package distribute
 
 import (
 	"fmt"
+	"github.com/4nte/protodist/config"
 	"github.com/4nte/protodist/git"
+	"github.com/4nte/protodist/internal/descriptor"
+	"github.com/4nte/protodist/internal/release"
 	"github.com/4nte/protodist/internal/target"
+	"google.golang.org/protobuf/types/descriptorpb"
 	"io/ioutil"
 	"log"
 	"os"
@@ -11,7 +15,10 @@ import (
 )
 
 // Distribute proto to files
-func Distribute(gitCfg git.Config, protoOutDir string, dryRun bool, deployTarget string, deployDir string) {
+// Release versions of packages and breaking change check are based on changes of proto.DescriptorSet
+// against the previous release, see config.Release.
+// Optional language targets run only when there is output of their language in proto out dir.
+func Distribute(gitCfg git.Config, proto config.Proto, releaseCfg config.Release, targets config.Targets, dryRun bool, deployTarget string, deployDir string) {
 	if dryRun {
 		fmt.Println("Dry run. Changes won't be pushed to GIT.")
 	}
@@ -27,6 +34,12 @@ func Distribute(gitCfg git.Config, protoOutDir string, dryRun bool, deployTarget
 		panic(err)
 	}
 
+	// Token is handed to git through GIT_ASKPASS, it never becomes a part of remote URL
+	err = git.SetupCredentials(gitCfg, cloneDir)
+	if err != nil {
+		panic(err)
+	}
+
 	err = os.Setenv("GIT_AUTHOR_NAME", "protodist")
 	if err != nil {
 		panic(err)
@@ -39,18 +52,167 @@ func Distribute(gitCfg git.Config, protoOutDir string, dryRun bool, deployTarget
 	cloneBranch := "master"
 
 	// if ref is a branch, then the new branch will be created or checked out with the same branch name of the ref
-	if deployTarget == "git" {
+	// pull requests are opened against the base branch, so it is checked out instead
+	if deployTarget == "git" && gitCfg.Publish == git.PublishPullRequest {
+		cloneBranch = gitCfg.PullRequestBase
+	} else if deployTarget == "git" {
 		if refType, refValue := gitCfg.ParseRef(); refType == "branch" {
 			cloneBranch = refValue
 		}
 	}
 
+	if releaseCfg.CheckBreaking != "" {
+		checkBreaking(gitCfg, proto, releaseCfg, targets)
+	}
+
+	if releaseCfg.Bump == "auto" {
+		gitCfg.Versions = planReleases(gitCfg, proto, releaseCfg, targets)
+	}
+
+	// Targets write into subdirectories of a monorepo, which is cloned once and published after all targets are done
+	if gitCfg.Monorepo != "" {
+		if gitCfg.SparseCheckout {
+			gitCfg.SparseDirs = monorepoDirs(proto.OutDir)
+		}
+		git.CloneOrCreate(gitCfg, gitCfg.Monorepo, cloneBranch, dryRun)
+	}
+
 	if deployTarget == "local" {
-		target.Golang(protoOutDir, gitCfg, cloneBranch, cloneDir, dryRun, deployTarget, deployDir)
+		target.Golang(proto, gitCfg, cloneBranch, cloneDir, dryRun, deployTarget, deployDir)
 	} else {
-		target.Golang(protoOutDir, gitCfg, cloneBranch, cloneDir, dryRun, deployTarget, deployDir)
-		target.Javascript(protoOutDir, gitCfg, cloneBranch, cloneDir, dryRun, deployTarget, deployDir)
-		target.C(protoOutDir, gitCfg, cloneBranch, cloneDir, dryRun, deployTarget, deployDir)
+		target.Golang(proto, gitCfg, cloneBranch, cloneDir, dryRun, deployTarget, deployDir)
+		target.Javascript(proto, gitCfg, cloneBranch, cloneDir, dryRun, deployTarget, deployDir, targets.Javascript)
+		target.C(proto, gitCfg, cloneBranch, cloneDir, dryRun, deployTarget, deployDir, targets.C)
+		if hasOutput(proto.OutDir, "python") {
+			target.Python(proto, gitCfg, cloneBranch, cloneDir, dryRun, deployTarget, deployDir, targets.Python)
+		}
+		if hasOutput(proto.OutDir, "rust") {
+			target.Rust(proto, gitCfg, cloneBranch, cloneDir, dryRun, deployTarget, deployDir, targets.Rust)
+		}
+		if hasOutput(proto.OutDir, "java") {
+			target.Java(proto, gitCfg, cloneBranch, cloneDir, dryRun, deployTarget, deployDir, targets.Java)
+		}
+		if hasOutput(proto.OutDir, "swift") {
+			target.Swift(proto, gitCfg, cloneBranch, cloneDir, dryRun, deployTarget, deployDir, targets.Swift)
+		}
+		if hasOutput(proto.OutDir, "csharp") {
+			target.CSharp(proto, gitCfg, cloneBranch, cloneDir, dryRun, deployTarget, deployDir, targets.CSharp)
+		}
+		if hasOutput(proto.OutDir, "dart") {
+			target.Dart(proto, gitCfg, cloneBranch, cloneDir, dryRun, deployTarget, deployDir, targets.Dart)
+		}
+	}
+
+	if gitCfg.Monorepo != "" {
+		target.AddCommitTagPublish(gitCfg.Release(gitCfg.Monorepo, ""), gitCfg.Monorepo, monorepoPackages(proto), dryRun)
+		// npm packages of monorepo are published once the monorepo is pushed
+		if deployTarget != "local" {
+			target.PublishJavascript(proto, gitCfg, cloneDir, dryRun, targets.Javascript)
+		}
+	}
+
+}
+
+// hasOutput reports whether there is a language dir in proto out dir
+func hasOutput(protoOutDir string, lang string) bool {
+	info, err := os.Stat(path.Join(protoOutDir, lang))
+	return err == nil && info.IsDir()
+}
+
+// monorepoDirs lists monorepo dirs of languages which have output in proto out dir (e.g go, js)
+func monorepoDirs(protoOutDir string) []string {
+	var dirs []string
+	for _, lang := range []string{"go", "ts", "c", "python", "rust", "java", "swift", "csharp", "dart"} {
+		if !hasOutput(protoOutDir, lang) {
+			continue
+		}
+		// Javascript packages reside in js dir of monorepo
+		if lang == "ts" {
+			lang = "js"
+		}
+		dirs = append(dirs, lang)
+	}
+
+	return dirs
+}
+
+// monorepoPackages lists packages of all languages as <lang>/<pkg>.
+// Packages are taken from the model when descriptor set is configured, otherwise every directory is a package.
+func monorepoPackages(proto config.Proto) []string {
+	var model *descriptor.Model
+	if proto.DescriptorSet != "" {
+		var err error
+		model, err = descriptor.LoadModel(proto.DescriptorSet, proto.OutDir)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var packages []string
+	for _, lang := range []string{"go", "ts", "c", "python", "rust", "java", "swift", "csharp", "dart"} {
+		if model != nil {
+			for _, pkg := range model.PackagesOf(proto.OutDir, lang) {
+				packages = append(packages, path.Join(lang, pkg))
+			}
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(path.Join(proto.OutDir, lang))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				packages = append(packages, path.Join(lang, entry.Name()))
+			}
+		}
+	}
+
+	return packages
+}
+
+func planReleases(gitCfg git.Config, proto config.Proto, releaseCfg config.Release, targets config.Targets) map[string]string {
+	current, previous := loadDescriptorSets(proto, releaseCfg)
+	model := descriptor.NewModel(current, descriptor.HasGeneratedCode(proto.OutDir))
+	return release.Plan(gitCfg, release.Units(gitCfg, proto.OutDir, model, targets), current, previous)
+}
+
+// checkBreaking exits if there are breaking changes against the previous release
+func checkBreaking(gitCfg git.Config, proto config.Proto, releaseCfg config.Release, targets config.Targets) {
+	category, err := descriptor.ParseCategory(releaseCfg.CheckBreaking)
+	if err != nil {
+		panic(err)
+	}
+
+	current, previous := loadDescriptorSets(proto, releaseCfg)
+	model := descriptor.NewModel(current, descriptor.HasGeneratedCode(proto.OutDir))
+	packages := release.Packages(release.Units(gitCfg, proto.OutDir, model, targets))
+	ok, err := release.CheckBreaking(gitCfg, packages, current, previous, category)
+	if err != nil {
+		log.Fatalf("failed to check breaking changes: %s", err)
+	}
+	if !ok {
+		log.Fatal("release has breaking changes")
+	}
+}
+
+// loadDescriptorSets loads current descriptor set, and the previous one if it is configured
+func loadDescriptorSets(proto config.Proto, releaseCfg config.Release) (*descriptorpb.FileDescriptorSet, *descriptorpb.FileDescriptorSet) {
+	if proto.DescriptorSet == "" {
+		panic("descriptor set is required for version bump and breaking change check")
+	}
+	current, err := descriptor.Load(proto.DescriptorSet)
+	if err != nil {
+		panic(err)
+	}
+
+	var previous *descriptorpb.FileDescriptorSet
+	if releaseCfg.PreviousDescriptorSet != "" {
+		previous, err = descriptor.Load(releaseCfg.PreviousDescriptorSet)
+		if err != nil {
+			panic(err)
+		}
 	}
 
+	return current, previous
 }
@@ -0,0 +1,32 @@
+package release
+
+import (
+	"os"
+
+	"github.com/4nte/protodist/git"
+	"github.com/4nte/protodist/internal/descriptor"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// CheckBreaking compares packages of current descriptor set with their previous release (see PreviousSet),
+// breaking changes of the category are reported. false is returned if there are any, or when a previous release
+// can't be looked up.
+func CheckBreaking(cfg git.Config, packages []string, current *descriptorpb.FileDescriptorSet, previous *descriptorpb.FileDescriptorSet, category descriptor.Category) (bool, error) {
+	ok := true
+	for _, pkg := range packages {
+		previousSet, err := PreviousSet(cfg, pkg, previous)
+		if err != nil {
+			return false, err
+		}
+		changes := descriptor.Compare(previousSet, descriptor.PackageSet(current, pkg))
+		breakingChanges := descriptor.Breaking(changes, category)
+		if len(breakingChanges) == 0 {
+			continue
+		}
+
+		ok = false
+		descriptor.Report(os.Stdout, pkg, category, breakingChanges)
+	}
+
+	return ok, nil
+}
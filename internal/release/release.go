@@ -0,0 +1,282 @@
+package release
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/4nte/protodist/config"
+	"github.com/4nte/protodist/git"
+	"github.com/4nte/protodist/internal/descriptor"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ProtosetFile is published along with Go packages, it holds the descriptor set of the released package
+const ProtosetFile = "protodist.protoset"
+
+// Version vMAJOR.MINOR.PATCH
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+var versionRegexp = regexp.MustCompile(`^v(\d+)\.(\d+)\.(\d+)$`)
+
+// ParseVersion parses a release version, pre-release versions are not accepted
+func ParseVersion(s string) (Version, bool) {
+	matches := versionRegexp.FindStringSubmatch(s)
+	if matches == nil {
+		return Version{}, false
+	}
+
+	var numbers [3]int
+	for i := range numbers {
+		n, err := strconv.Atoi(matches[i+1])
+		if err != nil {
+			return Version{}, false
+		}
+		numbers[i] = n
+	}
+
+	return Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, true
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+func (v Version) Less(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+
+	return v.Patch < other.Patch
+}
+
+func (v Version) Bump(bump descriptor.Bump) Version {
+	switch bump {
+	case descriptor.Major:
+		return Version{Major: v.Major + 1}
+	case descriptor.Minor:
+		return Version{Major: v.Major, Minor: v.Minor + 1}
+	}
+
+	return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+}
+
+// Latest returns the highest version among tags with prefix (e.g. go/foo/ for modules in monorepo)
+func Latest(tags []string, prefix string) (Version, bool) {
+	var latest Version
+	var found bool
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, prefix) {
+			continue
+		}
+		version, ok := ParseVersion(strings.TrimPrefix(tag, prefix))
+		if !ok {
+			continue
+		}
+		if !found || latest.Less(version) {
+			latest = version
+			found = true
+		}
+	}
+
+	return latest, found
+}
+
+// Unit is released under a single tag: a repo, or a directory of monorepo with its own tags (Go module)
+type Unit struct {
+	Repo     string
+	Dir      string
+	Packages []string
+}
+
+func (u Unit) tagPrefix() string {
+	if u.Dir == "" {
+		return ""
+	}
+
+	return u.Dir + "/"
+}
+
+// Packages returns packages of all release units
+func Packages(units []Unit) []string {
+	var packages []string
+	for _, unit := range units {
+		for _, pkg := range unit.Packages {
+			packages = appendUnique(packages, pkg)
+		}
+	}
+
+	return packages
+}
+
+// Units returns release units of packages of the model, which have generated code in proto out dir.
+// JS packages are a single unit, or a unit per package when targets.Javascript.PerPackage is set.
+func Units(cfg git.Config, protoOutDir string, model *descriptor.Model, targets config.Targets) []Unit {
+	var units []Unit
+	var allPackages []string
+	var tsPackages []string
+	var swiftPackages []string
+
+	for _, pkg := range model.PackagesOf(protoOutDir, "go") {
+		repo, dir := cfg.PackageRepo("go", pkg)
+		units = append(units, Unit{Repo: repo, Dir: dir, Packages: []string{pkg}})
+		allPackages = appendUnique(allPackages, pkg)
+	}
+	for _, pkg := range model.PackagesOf(protoOutDir, "ts") {
+		allPackages = appendUnique(allPackages, pkg)
+		if targets.Javascript.PerPackage {
+			if cfg.Monorepo == "" {
+				repo, _ := cfg.PackageRepo("js", pkg)
+				units = append(units, Unit{Repo: repo, Packages: []string{pkg}})
+			}
+			continue
+		}
+		tsPackages = append(tsPackages, pkg)
+	}
+	for _, pkg := range model.PackagesOf(protoOutDir, "swift") {
+		swiftPackages = append(swiftPackages, pkg)
+		allPackages = appendUnique(allPackages, pkg)
+	}
+	for _, lang := range []string{"c", "python", "rust", "java", "csharp", "dart"} {
+		for _, pkg := range model.PackagesOf(protoOutDir, lang) {
+			allPackages = appendUnique(allPackages, pkg)
+			if cfg.Monorepo == "" {
+				repo, _ := cfg.PackageRepo(lang, pkg)
+				units = append(units, Unit{Repo: repo, Packages: []string{pkg}})
+			}
+		}
+	}
+
+	// JS and C reside in monorepo root, so monorepo is tagged with the highest version bump of all packages
+	if cfg.Monorepo != "" {
+		units = append(units, Unit{Repo: cfg.Monorepo, Packages: allPackages})
+		return units
+	}
+	if len(tsPackages) > 0 {
+		units = append(units, Unit{Repo: "proto-all-js", Packages: tsPackages})
+	}
+	if len(swiftPackages) > 0 {
+		units = append(units, Unit{Repo: "proto-all-swift", Packages: swiftPackages})
+	}
+
+	return units
+}
+
+// Plan computes the next version of each release unit, keyed by path.Join(Repo, Dir).
+// Packages of current descriptor set are compared with previous one, or with descriptor sets
+// published along with the latest release of Go packages when previous is nil.
+func Plan(cfg git.Config, units []Unit, current *descriptorpb.FileDescriptorSet, previous *descriptorpb.FileDescriptorSet) map[string]string {
+	tagsCache := make(map[string][]string)
+	repoTags := func(repo string) []string {
+		if tags, ok := tagsCache[repo]; ok {
+			return tags
+		}
+		// Repo which doesn't exist yet has no releases, other errors would reset its version
+		tags, err := git.Tags(cfg, repo)
+		if err != nil && err != git.ErrRepoNotExist {
+			panic(err)
+		}
+		tagsCache[repo] = tags
+		return tags
+	}
+
+	bumps := make(map[string]descriptor.Bump)
+	packageBump := func(pkg string) descriptor.Bump {
+		if bump, ok := bumps[pkg]; ok {
+			return bump
+		}
+
+		previousSet, err := PreviousSet(cfg, pkg, previous)
+		if err != nil {
+			panic(err)
+		}
+		changes := descriptor.Compare(previousSet, descriptor.PackageSet(current, pkg))
+		bump := descriptor.RequiredBump(changes)
+		fmt.Printf("package %s requires %s version bump\n", pkg, bump)
+		for _, change := range changes {
+			fmt.Printf("\t%s\n", change)
+		}
+		bumps[pkg] = bump
+		return bump
+	}
+
+	versions := make(map[string]string)
+	for _, unit := range units {
+		bump := descriptor.Patch
+		for _, pkg := range unit.Packages {
+			if pkgBump := packageBump(pkg); pkgBump > bump {
+				bump = pkgBump
+			}
+		}
+
+		latest, _ := Latest(repoTags(unit.Repo), unit.tagPrefix())
+		next := latest.Bump(bump)
+		fmt.Printf("release %s: %s -> %s\n", path.Join(unit.Repo, unit.Dir), latest, next)
+		versions[path.Join(unit.Repo, unit.Dir)] = next.String()
+	}
+
+	return versions
+}
+
+// PreviousSet returns descriptor set of a package from previous, or the one published with the latest release
+// of the Go package when previous is nil. Empty set is returned if the repo or a release doesn't exist,
+// any other failure to look the release up is returned as an error, so that breaking changes are never missed.
+func PreviousSet(cfg git.Config, pkg string, previous *descriptorpb.FileDescriptorSet) (*descriptorpb.FileDescriptorSet, error) {
+	if previous != nil {
+		return descriptor.PackageSet(previous, pkg), nil
+	}
+
+	repo, dir := cfg.PackageRepo("go", pkg)
+	tags, err := git.Tags(cfg, repo)
+	if err == git.ErrRepoNotExist {
+		return &descriptorpb.FileDescriptorSet{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	latest, ok := Latest(tags, prefix)
+	if !ok {
+		return &descriptorpb.FileDescriptorSet{}, nil
+	}
+
+	data, err := git.ReadFile(cfg, repo, "refs/tags/"+prefix+latest.String(), path.Join(dir, ProtosetFile))
+	if os.IsNotExist(err) {
+		fmt.Printf("warning: %s wasn't published with %s %s\n", ProtosetFile, repo, latest)
+		return &descriptorpb.FileDescriptorSet{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := descriptor.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s of %s %s: %s", ProtosetFile, repo, latest, err)
+	}
+
+	return descriptor.PackageSet(set, pkg), nil
+}
+
+func appendUnique(list []string, item string) []string {
+	for _, existing := range list {
+		if existing == item {
+			return list
+		}
+	}
+
+	return append(list, item)
+}
@@ -2,31 +2,29 @@ package target
 
 import (
 	"fmt"
+	"github.com/4nte/protodist/config"
 	"github.com/4nte/protodist/git"
 	"github.com/4nte/protodist/util"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
 )
 
-func C(protoOutDir string, gitCfg git.Config, cloneBranch string, cloneDir string, dryRun bool, deployTarget string, deployDir string) {
-	filterPackages := []string{"gateway", "device"}
-	var scannedPackages []string
+// Include directives of nanopb generated headers (e.g #include "foo/foo.pb.h")
+var cIncludeRegexp = regexp.MustCompile(`(#include\s+")([^"]+\.pb\.h)(")`)
 
-	// Scan compiled go packages
-	files, err := ioutil.ReadDir(path.Join(protoOutDir, "c"))
-	if err != nil {
-		log.Fatal(err)
-	}
-	for _, f := range files {
-		if !f.IsDir() {
-			panic("file is not expected to be here, only directories")
-		}
-		scannedPackages = append(scannedPackages, f.Name())
-		//fmt.Println(f.Path())
-	}
+// Message structs of nanopb generated headers, along with callback fields which unbounded fields are generated as
+var cStructStartRegexp = regexp.MustCompile(`^typedef struct _\w+\s*\{`)
+var cStructEndRegexp = regexp.MustCompile(`^\}\s*(\w+);`)
+var cCallbackFieldRegexp = regexp.MustCompile(`\bpb_callback_t\s+(\w+);`)
+
+func C(proto config.Proto, gitCfg git.Config, cloneBranch string, cloneDir string, dryRun bool, deployTarget string, deployDir string, cCfg config.C) {
+	filterPackages := []string{"gateway", "device"}
+	model := loadModel(proto)
+	scannedPackages := discoverPackages(proto.OutDir, model, "c")
 
 	// Append packages to cPackages that match the filter
 	var cPackages []string
@@ -39,81 +37,199 @@ func C(protoOutDir string, gitCfg git.Config, cloneBranch string, cloneDir strin
 		}
 	}
 
-	// Clone C proto repos
-	for _, pkg := range cPackages {
-		repoName := fmt.Sprintf("proto-%s-c", pkg)
-		repoUrl := gitCfg.GetRepoURL(repoName)
-		git.Clone(repoUrl, cloneBranch)
+	// Clone C proto repos, monorepo is already cloned
+	if gitCfg.Monorepo == "" {
+		for _, pkg := range cPackages {
+			repoName, _ := gitCfg.PackageRepo("c", pkg)
+			git.CloneOrCreate(gitCfg, repoName, cloneBranch, dryRun)
+		}
 	}
 
-	// Delete all .go files in cloned go proto repos
 	for _, pkg := range cPackages {
-		repoName := fmt.Sprintf("proto-%s-c", pkg)
-		repoDir := path.Join(cloneDir, repoName)
-		pkgCloneDir, err := ioutil.ReadDir(repoDir)
+		repoName, pkgDir := gitCfg.PackageRepo("c", pkg)
+		repoDir := path.Join(cloneDir, repoName, pkgDir)
+
+		// Layout of the package is assembled in a staging dir, rather than in the generated output dir,
+		// and synced exactly into the repo dir, so that files of deleted protos are removed
+		stagingDir, err := ioutil.TempDir("", "protodist-c-*")
 		if err != nil {
 			panic(err)
 		}
+		generatedPkgDir := path.Join(proto.OutDir, "c", pkg)
+		stageCPackage(generatedPkgDir, stagingDir, pkg, cPackages, cCfg.IncludePrefix)
+		var optionsFiles []string
+		if proto.SrcDir != "" {
+			optionsFiles = stageCOptions(path.Join(proto.SrcDir, pkg), stagingDir)
+		}
+		checkUnboundedFields(generatedPkgDir, pkg, optionsFiles, cCfg.UnboundedFields)
 
-		// Search for files with .go extension && delete them
-		for _, file := range pkgCloneDir {
-
-			// Filter directories
-			if file.IsDir() && file.Name() != pkg {
-				continue
+		// Included headers of other packages are a fallback, only filtered packages are distributed as C packages
+		var deps []string
+		for _, dep := range familyDeps(model, "c", pkg, scannedPackages, func() []string {
+			var deps []string
+			for _, match := range cIncludeRegexp.FindAllStringSubmatch(readSources(generatedPkgDir, ".c", ".h"), -1) {
+				dep := strings.SplitN(match[2], "/", 2)[0]
+				if dep != pkg && containsString(scannedPackages, dep) && !containsString(deps, dep) {
+					deps = append(deps, dep)
+				}
 			}
-			// skip deleting files which are not .c or .h
-			if filepath.Ext(file.Name()) != ".c" && filepath.Ext(file.Name()) != ".h" {
+			return deps
+		}) {
+			if !containsString(cPackages, dep) {
+				fmt.Printf("warning: package %s depends on %s, which isn't distributed as a C package\n", pkg, dep)
 				continue
 			}
-			// Delete file
-			err := os.Remove(file.Name())
-			if err != nil {
-				panic(err)
-			}
+			deps = append(deps, dep)
 		}
+		writeCManifests(stagingDir, gitCfg, cCfg, pkg, deps)
 
-		// Move generate .c files to cloned repo dir
-		generatedPkgDirPath := path.Join(protoOutDir, "c", pkg)
-		err = util.CopyDirectory(generatedPkgDirPath, repoDir)
+		err = util.SyncDirectory(stagingDir, repoDir, cCfg.Protected)
+		os.RemoveAll(stagingDir)
 		if err != nil {
 			panic(err)
 		}
+	}
 
-		// nanopb imports fix
-		// generated C files expect header files to reside in `package/foo.pb.h` path, but they are all in the same directory
-		// Here we are creating a sub-directory with the name of a proto package, and moving the header files into it.
-		headerDirPath := path.Join(generatedPkgDirPath, pkg)
-		err = util.CreateIfNotExists(headerDirPath, 0755)
+	// Monorepo is published once all targets are done
+	if gitCfg.Monorepo != "" {
+		return
+	}
+	for _, cPkg := range cPackages {
+		repoName, _ := gitCfg.PackageRepo("c", cPkg)
+		AddCommitTagPublish(gitCfg.Release(repoName, ""), repoName, []string{cPkg}, dryRun)
+	}
+}
+
+// stageCPackage lays out generated files of a package in a staging dir. Nanopb generated files include headers by
+// their path relative to proto root (e.g "foo/foo.pb.h"), so headers are placed into <includePrefix>/<pkg> dir,
+// while sources reside in the root. Include directives of packages are prefixed with includePrefix.
+func stageCPackage(generatedPkgDir string, stagingDir string, pkg string, cPackages []string, includePrefix string) {
+	headerDir := path.Join(stagingDir, includePrefix, pkg)
+	err := filepath.Walk(generatedPkgDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(generatedPkgDir, filePath)
 		if err != nil {
-			panic(err)
+			return err
+		}
+
+		// Headers already placed into <pkg> dir (e.g by a previous protodist version) keep their path within it
+		target := path.Join(stagingDir, relPath)
+		if filepath.Ext(relPath) == ".h" {
+			target = path.Join(headerDir, strings.TrimPrefix(filepath.ToSlash(relPath), pkg+"/"))
+		}
+		if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+			return err
 		}
 
-		generatedPkgDir, err := ioutil.ReadDir(generatedPkgDirPath)
+		data, err := ioutil.ReadFile(filePath)
 		if err != nil {
-			panic(err)
+			return err
+		}
+		if ext := filepath.Ext(relPath); includePrefix != "" && (ext == ".c" || ext == ".h") {
+			data = []byte(prefixCIncludes(string(data), cPackages, includePrefix))
 		}
-		for _, file := range generatedPkgDir {
-			if filepath.Ext(file.Name()) == ".h" {
-				originalFile := path.Join(generatedPkgDirPath, file.Name())
-				err = util.Copy(path.Join(originalFile), path.Join(headerDirPath, file.Name()))
-				if err != nil {
-					panic(err)
-				}
 
-				// Delete the original file
-				err := os.Remove(originalFile)
-				if err != nil {
-					panic(err)
-				}
-			}
+		return ioutil.WriteFile(target, data, info.Mode())
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to stage C package %s: %s", pkg, err))
+	}
+}
+
+// prefixCIncludes prefixes include directives of package headers (e.g "foo/foo.pb.h" into "proto/foo/foo.pb.h")
+func prefixCIncludes(source string, cPackages []string, includePrefix string) string {
+	return cIncludeRegexp.ReplaceAllStringFunc(source, func(directive string) string {
+		match := cIncludeRegexp.FindStringSubmatch(directive)
+		if !containsString(cPackages, strings.SplitN(match[2], "/", 2)[0]) {
+			return directive
 		}
+		return match[1] + path.Join(includePrefix, match[2]) + match[3]
+	})
+}
 
+// stageCOptions copies nanopb .options files of a package source dir into a staging dir, so that the limits
+// generated code was built with are published along with it. Paths of options files relative to the package are returned.
+func stageCOptions(srcPkgDir string, stagingDir string) []string {
+	if !util.Exists(srcPkgDir) {
+		return nil
 	}
 
-	var repoNames []string
-	for _, cPkg := range cPackages {
-		repoNames = append(repoNames, fmt.Sprintf("proto-%s-c", cPkg))
+	var optionsFiles []string
+	err := filepath.Walk(srcPkgDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(filePath) != ".options" {
+			return err
+		}
+		relPath, err := filepath.Rel(srcPkgDir, filePath)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(path.Dir(path.Join(stagingDir, relPath)), 0755); err != nil {
+			return err
+		}
+		optionsFiles = append(optionsFiles, filepath.ToSlash(relPath))
+		return util.Copy(filePath, path.Join(stagingDir, relPath))
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return optionsFiles
+}
+
+// checkUnboundedFields reports messages of protos without .options file, which have unbounded string, bytes or repeated
+// fields. Nanopb generates such fields as callbacks, rather than static arrays, unless their limits are set by options.
+// Messages are reported with a warning, or they fail the distribution in fail mode.
+func checkUnboundedFields(generatedPkgDir string, pkg string, optionsFiles []string, mode string) {
+	unboundedMessages := findUnboundedMessages(generatedPkgDir, pkg, optionsFiles)
+	for _, message := range unboundedMessages {
+		fmt.Printf("warning: message %s has unbounded fields, which are generated as callbacks, set their max_size and max_count in nanopb .options file\n", message)
 	}
-	AddCommitTagPush(gitCfg, repoNames, dryRun)
+	if mode == "fail" && len(unboundedMessages) > 0 {
+		panic(fmt.Sprintf("package %s has messages with unbounded fields, but no nanopb options", pkg))
+	}
+}
+
+// findUnboundedMessages returns messages with callback fields in generated headers of protos without .options file
+func findUnboundedMessages(generatedPkgDir string, pkg string, optionsFiles []string) []string {
+	var unboundedMessages []string
+	err := filepath.Walk(generatedPkgDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(filePath, ".pb.h") {
+			return err
+		}
+		relPath, err := filepath.Rel(generatedPkgDir, filePath)
+		if err != nil {
+			return err
+		}
+		// Options file of foo.proto is foo.options, headers may reside in <pkg> dir already
+		protoPath := strings.TrimSuffix(strings.TrimPrefix(filepath.ToSlash(relPath), pkg+"/"), ".pb.h")
+		if containsString(optionsFiles, protoPath+".options") {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		var callbackFields []string
+		for _, line := range strings.Split(string(data), "\n") {
+			if cStructStartRegexp.MatchString(line) {
+				callbackFields = nil
+			}
+			if match := cCallbackFieldRegexp.FindStringSubmatch(line); match != nil {
+				callbackFields = append(callbackFields, match[1])
+			}
+			if match := cStructEndRegexp.FindStringSubmatch(line); match != nil && len(callbackFields) > 0 {
+				unboundedMessages = append(unboundedMessages, fmt.Sprintf("%s (%s) of %s.proto", match[1], strings.Join(callbackFields, ", "), path.Join(pkg, protoPath)))
+				callbackFields = nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return unboundedMessages
 }
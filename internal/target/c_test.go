@@ -0,0 +1,216 @@
+package target
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPrefixCIncludes(t *testing.T) {
+	source := `#include <pb.h>
+#include "foo/foo.pb.h"
+#include  "bar/sub/bar.pb.h"
+#include "baz/baz.pb.h"
+#include "foo/foo.h"
+#include "nanopb/pb_common.pb.h"
+`
+	want := `#include <pb.h>
+#include "proto/foo/foo.pb.h"
+#include  "proto/bar/sub/bar.pb.h"
+#include "baz/baz.pb.h"
+#include "foo/foo.h"
+#include "nanopb/pb_common.pb.h"
+`
+	if got := prefixCIncludes(source, []string{"foo", "bar"}, "proto"); got != want {
+		t.Errorf("prefixCIncludes() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestStageCPackage(t *testing.T) {
+	generatedPkgDir := t.TempDir()
+	generated := map[string]string{
+		"foo.pb.c":         "#include \"foo/foo.pb.h\"\n",
+		"foo.pb.h":         "#include \"bar/bar.pb.h\"\n",
+		"sub/sub.pb.c":     "#include \"foo/sub/sub.pb.h\"\n",
+		"sub/sub.pb.h":     "#include <pb.h>\n",
+		"foo/legacy.pb.h":  "#include \"foo/foo.pb.h\"\n",
+		"foo/foo.options":  "foo.Foo.name max_size:16\n",
+		"other/other.pb.h": "#include \"other/other.pb.h\"\n",
+	}
+	for name, content := range generated {
+		if err := os.MkdirAll(path.Dir(path.Join(generatedPkgDir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(generatedPkgDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		includePrefix string
+		want          map[string]string
+	}{
+		{
+			name: "without include prefix",
+			want: map[string]string{
+				"foo.pb.c":             "#include \"foo/foo.pb.h\"\n",
+				"foo/foo.pb.h":         "#include \"bar/bar.pb.h\"\n",
+				"sub/sub.pb.c":         "#include \"foo/sub/sub.pb.h\"\n",
+				"foo/sub/sub.pb.h":     "#include <pb.h>\n",
+				"foo/legacy.pb.h":      "#include \"foo/foo.pb.h\"\n",
+				"foo/foo.options":      "foo.Foo.name max_size:16\n",
+				"foo/other/other.pb.h": "#include \"other/other.pb.h\"\n",
+			},
+		},
+		{
+			name:          "with include prefix",
+			includePrefix: "proto",
+			want: map[string]string{
+				"foo.pb.c":                   "#include \"proto/foo/foo.pb.h\"\n",
+				"proto/foo/foo.pb.h":         "#include \"proto/bar/bar.pb.h\"\n",
+				"sub/sub.pb.c":               "#include \"proto/foo/sub/sub.pb.h\"\n",
+				"proto/foo/sub/sub.pb.h":     "#include <pb.h>\n",
+				"proto/foo/legacy.pb.h":      "#include \"proto/foo/foo.pb.h\"\n",
+				"foo/foo.options":            "foo.Foo.name max_size:16\n",
+				"proto/foo/other/other.pb.h": "#include \"other/other.pb.h\"\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		stagingDir := t.TempDir()
+		stageCPackage(generatedPkgDir, stagingDir, "foo", []string{"foo", "bar"}, test.includePrefix)
+
+		got := make(map[string]string)
+		err := filepath.Walk(stagingDir, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			relPath, err := filepath.Rel(stagingDir, filePath)
+			if err != nil {
+				return err
+			}
+			data, err := ioutil.ReadFile(filePath)
+			got[filepath.ToSlash(relPath)] = string(data)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: staged %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckUnboundedFields(t *testing.T) {
+	boundedHeader := `typedef struct _foo_Foo {
+    char name[16];
+    pb_size_t ids_count;
+    int32_t ids[8];
+} foo_Foo;
+`
+	unboundedHeader := `typedef struct _foo_Foo {
+    pb_callback_t name;
+    int32_t id;
+} foo_Foo;
+
+typedef struct _foo_Blob {
+    pb_callback_t data;
+    bool compressed;
+} foo_Blob;
+
+typedef struct _foo_List {
+    pb_callback_t items;
+} foo_List;
+
+typedef struct _foo_Empty {
+    char dummy_field;
+} foo_Empty;
+`
+	unboundedMessages := []string{
+		"foo_Foo (name) of foo/foo.proto",
+		"foo_Blob (data) of foo/foo.proto",
+		"foo_List (items) of foo/foo.proto",
+	}
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		options      map[string]string
+		mode         string
+		wantMessages []string
+		wantPanic    bool
+	}{
+		{
+			name:    "bounded fields",
+			headers: map[string]string{"foo.pb.h": boundedHeader},
+			mode:    "fail",
+		},
+		{
+			name:         "unbounded string, bytes and repeated fields in warn mode",
+			headers:      map[string]string{"foo.pb.h": unboundedHeader},
+			mode:         "warn",
+			wantMessages: unboundedMessages,
+		},
+		{
+			name:         "unbounded string, bytes and repeated fields in fail mode",
+			headers:      map[string]string{"foo.pb.h": unboundedHeader},
+			mode:         "fail",
+			wantMessages: unboundedMessages,
+			wantPanic:    true,
+		},
+		{
+			name:         "unbounded fields of header in package dir",
+			headers:      map[string]string{"foo/foo.pb.h": unboundedHeader},
+			mode:         "warn",
+			wantMessages: unboundedMessages,
+		},
+		{
+			name:    "wildcard options entry",
+			headers: map[string]string{"foo.pb.h": unboundedHeader},
+			options: map[string]string{"foo.options": "foo.* max_size:64 max_count:16\n"},
+			mode:    "fail",
+		},
+		{
+			name:         "options of other proto",
+			headers:      map[string]string{"foo.pb.h": unboundedHeader, "sub/bar.pb.h": boundedHeader},
+			options:      map[string]string{"sub/bar.options": "*.name max_size:16\n"},
+			mode:         "fail",
+			wantMessages: unboundedMessages,
+			wantPanic:    true,
+		},
+	}
+
+	for _, test := range tests {
+		generatedPkgDir := t.TempDir()
+		srcPkgDir := t.TempDir()
+		for dir, files := range map[string]map[string]string{generatedPkgDir: test.headers, srcPkgDir: test.options} {
+			for name, content := range files {
+				if err := os.MkdirAll(path.Dir(path.Join(dir, name)), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		optionsFiles := stageCOptions(srcPkgDir, t.TempDir())
+
+		if got := findUnboundedMessages(generatedPkgDir, "foo", optionsFiles); !reflect.DeepEqual(got, test.wantMessages) {
+			t.Errorf("%s: unbounded messages %v, want %v", test.name, got, test.wantMessages)
+		}
+		func() {
+			defer func() {
+				if panicked := recover() != nil; panicked != test.wantPanic {
+					t.Errorf("%s: checkUnboundedFields() panicked = %t, want %t", test.name, panicked, test.wantPanic)
+				}
+			}()
+			checkUnboundedFields(generatedPkgDir, "foo", optionsFiles, test.mode)
+		}()
+	}
+}
@@ -0,0 +1,232 @@
+package target
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/4nte/protodist/config"
+	"github.com/4nte/protodist/git"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const CMakeListsTemplate = `# Code generated by protodist. DO NOT EDIT.
+cmake_minimum_required(VERSION {{ .CMakeMinimumVersion }})
+project({{ .Name }}{{ if .CMakeVersion }} VERSION {{ .CMakeVersion }}{{ end }} LANGUAGES C)
+
+include(FetchContent)
+
+# Nanopb runtime is fetched unless the consuming project provides nanopb target
+if(NOT TARGET nanopb)
+  FetchContent_Declare(nanopb
+    GIT_REPOSITORY https://github.com/nanopb/nanopb.git
+    GIT_TAG nanopb-{{ .Nanopb }})
+  FetchContent_GetProperties(nanopb)
+  if(NOT nanopb_POPULATED)
+    FetchContent_Populate(nanopb)
+  endif()
+  add_library(nanopb STATIC
+    ${nanopb_SOURCE_DIR}/pb_common.c
+    ${nanopb_SOURCE_DIR}/pb_encode.c
+    ${nanopb_SOURCE_DIR}/pb_decode.c)
+  target_include_directories(nanopb PUBLIC ${nanopb_SOURCE_DIR})
+endif()
+{{ range .Dependencies }}
+if(NOT TARGET {{ .Name }})
+  FetchContent_Declare({{ .Name }}
+    GIT_REPOSITORY {{ .URL }}
+    GIT_TAG {{ .Ref }}
+{{- if .Path }}
+    SOURCE_SUBDIR {{ .Path }}
+{{- end }})
+  FetchContent_MakeAvailable({{ .Name }})
+endif()
+{{ end }}
+{{- if .Sources }}
+add_library({{ .Name }} STATIC
+{{- range .Sources }}
+  {{ . }}
+{{- end }})
+target_include_directories({{ .Name }} PUBLIC ${CMAKE_CURRENT_SOURCE_DIR})
+target_link_libraries({{ .Name }} PUBLIC nanopb{{ range .Dependencies }} {{ .Name }}{{ end }})
+{{- else }}
+add_library({{ .Name }} INTERFACE)
+target_include_directories({{ .Name }} INTERFACE ${CMAKE_CURRENT_SOURCE_DIR})
+target_link_libraries({{ .Name }} INTERFACE nanopb{{ range .Dependencies }} {{ .Name }}{{ end }})
+{{- end }}
+`
+
+const LibraryPropertiesTemplate = `name={{ .Name }}
+version={{ .Version }}
+author={{ .Owner }}
+maintainer={{ .Owner }}
+sentence=Protobuf package {{ .Package }}, generated by protodist.
+paragraph=Nanopb messages of protobuf package {{ .Package }}.{{ if .Dependencies }} Requires libraries{{ range $i, $dep := .Dependencies }}{{ if $i }},{{ end }} {{ $dep.Name }} ({{ $dep.URL }}{{ if $dep.Path }} dir {{ $dep.Path }}{{ end }}){{ end }}.{{ end }}
+category=Communication
+url={{ .URL }}
+architectures=*
+includes={{ join .Headers "," }}
+depends=Nanopb (>={{ .Nanopb }})
+`
+
+// Versions accepted by project() of CMake
+var cmakeVersionRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+){0,3}$`)
+
+// CDependency is a family package which a C package depends on, fetched from git
+type CDependency struct {
+	Name string
+	URL  string
+	Ref  string
+	Path string
+}
+
+// LibraryJSON is library.json manifest of a PlatformIO library
+type LibraryJSON struct {
+	Name         string                  `json:"name"`
+	Version      string                  `json:"version"`
+	Description  string                  `json:"description"`
+	Repository   map[string]string       `json:"repository"`
+	Frameworks   string                  `json:"frameworks"`
+	Platforms    string                  `json:"platforms"`
+	Dependencies []LibraryJSONDependency `json:"dependencies"`
+	Build        map[string]string       `json:"build"`
+}
+
+// LibraryJSONDependency is a PlatformIO library dependency, version is a semver range or a git URL
+type LibraryJSONDependency struct {
+	Owner   string `json:"owner,omitempty"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// writeCManifests writes manifests which make a staged C package consumable through CMake (CMakeLists.txt),
+// PlatformIO (library.json) and Arduino IDE (library.properties). Family packages are fetched from their git repos
+// by CMake. Arduino Library Manager resolves only its registered libraries, so family packages are listed in the
+// paragraph of library.properties rather than in depends, they are installed from their repos. PlatformIO fetches
+// family packages by git URL, which can't address a monorepo dir, so monorepo packages are left out of library.json
+// dependencies with a warning.
+func writeCManifests(stagingDir string, gitCfg git.Config, cCfg config.C, pkg string, deps []string) {
+	repoName, _ := gitCfg.PackageRepo("c", pkg)
+	name := "proto-" + pkg
+	version := releaseVersion(gitCfg, "c", pkg)
+
+	var sources, headers []string
+	err := filepath.Walk(stagingDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(stagingDir, filePath)
+		if err != nil {
+			return err
+		}
+		switch filepath.Ext(relPath) {
+		case ".c":
+			sources = append(sources, filepath.ToSlash(relPath))
+		case ".h":
+			headers = append(headers, filepath.ToSlash(relPath))
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	sort.Strings(sources)
+	sort.Strings(headers)
+
+	var dependencies []CDependency
+	hasSubdirDeps := false
+	for _, dep := range deps {
+		depRepo, depDir := gitCfg.PackageRepo("c", dep)
+		_, refName := gitCfg.Release(depRepo, "").ParseRef()
+		dependencies = append(dependencies, CDependency{Name: "proto-" + dep, URL: gitCfg.PackageURL(depRepo), Ref: refName, Path: depDir})
+		hasSubdirDeps = hasSubdirDeps || depDir != ""
+	}
+
+	// CMake project version is numeric, pre-release and build metadata are left out
+	cmakeVersion := strings.SplitN(strings.SplitN(version, "-", 2)[0], "+", 2)[0]
+	if !cmakeVersionRegexp.MatchString(cmakeVersion) {
+		cmakeVersion = ""
+	}
+	// SOURCE_SUBDIR of FetchContent, which monorepo packages are fetched with, requires CMake 3.18
+	cmakeMinimumVersion := "3.14"
+	if hasSubdirDeps {
+		cmakeMinimumVersion = "3.18"
+	}
+
+	data := struct {
+		Name                string
+		Version             string
+		Package             string
+		Owner               string
+		URL                 string
+		Nanopb              string
+		CMakeVersion        string
+		CMakeMinimumVersion string
+		Sources             []string
+		Headers             []string
+		Dependencies        []CDependency
+	}{
+		Name:                name,
+		Version:             version,
+		Package:             pkg,
+		Owner:               gitCfg.Owner,
+		URL:                 gitCfg.PackageURL(repoName),
+		Nanopb:              cCfg.Nanopb,
+		CMakeVersion:        cmakeVersion,
+		CMakeMinimumVersion: cmakeMinimumVersion,
+		Sources:             sources,
+		Headers:             headers,
+		Dependencies:        dependencies,
+	}
+	writeTemplate(path.Join(stagingDir, "CMakeLists.txt"), CMakeListsTemplate, data)
+	writeTemplate(path.Join(stagingDir, "library.properties"), LibraryPropertiesTemplate, data)
+
+	// Sources and headers reside in the root of a library, rather than in src and include dirs
+	libraryJSON := LibraryJSON{
+		Name:        name,
+		Version:     version,
+		Description: "Protobuf package " + pkg + ", generated by protodist",
+		Repository:  map[string]string{"type": "git", "url": gitCfg.PackageURL(repoName)},
+		Frameworks:  "*",
+		Platforms:   "*",
+		Dependencies: []LibraryJSONDependency{
+			{Owner: "nanopb", Name: "Nanopb", Version: "^" + cCfg.Nanopb},
+		},
+		Build: map[string]string{"srcDir": ".", "includeDir": "."},
+	}
+	for _, dep := range dependencies {
+		if dep.Path != "" {
+			fmt.Printf("warning: PlatformIO can't fetch %s from %s dir of monorepo, it is left out of dependencies of %s\n", dep.Name, dep.Path, name)
+			continue
+		}
+		libraryJSON.Dependencies = append(libraryJSON.Dependencies, LibraryJSONDependency{Name: dep.Name, Version: dep.URL + "#" + dep.Ref})
+	}
+	libraryJSONData, err := json.MarshalIndent(libraryJSON, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(path.Join(stagingDir, "library.json"), append(libraryJSONData, '\n'), 0644); err != nil {
+		panic(err)
+	}
+}
+
+func writeTemplate(filename string, text string, data interface{}) {
+	tmpl, err := template.New(path.Base(filename)).Funcs(template.FuncMap{"join": strings.Join}).Parse(text)
+	if err != nil {
+		panic(err)
+	}
+	buffer := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(buffer, data); err != nil {
+		panic(err)
+	}
+
+	if err := ioutil.WriteFile(filename, buffer.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+}
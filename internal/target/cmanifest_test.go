@@ -0,0 +1,222 @@
+package target
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/4nte/protodist/config"
+	"github.com/4nte/protodist/git"
+)
+
+const cmakeNanopbGolden = `include(FetchContent)
+
+# Nanopb runtime is fetched unless the consuming project provides nanopb target
+if(NOT TARGET nanopb)
+  FetchContent_Declare(nanopb
+    GIT_REPOSITORY https://github.com/nanopb/nanopb.git
+    GIT_TAG nanopb-0.4.7)
+  FetchContent_GetProperties(nanopb)
+  if(NOT nanopb_POPULATED)
+    FetchContent_Populate(nanopb)
+  endif()
+  add_library(nanopb STATIC
+    ${nanopb_SOURCE_DIR}/pb_common.c
+    ${nanopb_SOURCE_DIR}/pb_encode.c
+    ${nanopb_SOURCE_DIR}/pb_decode.c)
+  target_include_directories(nanopb PUBLIC ${nanopb_SOURCE_DIR})
+endif()
+`
+
+func TestWriteCManifests(t *testing.T) {
+	tests := []struct {
+		name     string
+		monorepo string
+		staged   []string
+		want     map[string]string
+	}{
+		{
+			name:   "single repo package with sources",
+			staged: []string{"foo.pb.c", "proto/foo/foo.pb.h"},
+			want: map[string]string{
+				"CMakeLists.txt": `# Code generated by protodist. DO NOT EDIT.
+cmake_minimum_required(VERSION 3.14)
+project(proto-foo VERSION 1.2.0 LANGUAGES C)
+
+` + cmakeNanopbGolden + `
+if(NOT TARGET proto-bar)
+  FetchContent_Declare(proto-bar
+    GIT_REPOSITORY https://github.com/org/proto-bar-c.git
+    GIT_TAG v1.2.0)
+  FetchContent_MakeAvailable(proto-bar)
+endif()
+
+add_library(proto-foo STATIC
+  foo.pb.c)
+target_include_directories(proto-foo PUBLIC ${CMAKE_CURRENT_SOURCE_DIR})
+target_link_libraries(proto-foo PUBLIC nanopb proto-bar)
+`,
+				"library.properties": `name=proto-foo
+version=1.2.0
+author=org
+maintainer=org
+sentence=Protobuf package foo, generated by protodist.
+paragraph=Nanopb messages of protobuf package foo. Requires libraries proto-bar (https://github.com/org/proto-bar-c.git).
+category=Communication
+url=https://github.com/org/proto-foo-c.git
+architectures=*
+includes=proto/foo/foo.pb.h
+depends=Nanopb (>=0.4.7)
+`,
+				"library.json": `{
+  "name": "proto-foo",
+  "version": "1.2.0",
+  "description": "Protobuf package foo, generated by protodist",
+  "repository": {
+    "type": "git",
+    "url": "https://github.com/org/proto-foo-c.git"
+  },
+  "frameworks": "*",
+  "platforms": "*",
+  "dependencies": [
+    {
+      "owner": "nanopb",
+      "name": "Nanopb",
+      "version": "^0.4.7"
+    },
+    {
+      "name": "proto-bar",
+      "version": "https://github.com/org/proto-bar-c.git#v1.2.0"
+    }
+  ],
+  "build": {
+    "includeDir": ".",
+    "srcDir": "."
+  }
+}
+`,
+			},
+		},
+		{
+			name:     "monorepo package with headers only",
+			monorepo: "proto",
+			staged:   []string{"proto/foo/foo.pb.h"},
+			want: map[string]string{
+				"CMakeLists.txt": `# Code generated by protodist. DO NOT EDIT.
+cmake_minimum_required(VERSION 3.18)
+project(proto-foo VERSION 1.2.0 LANGUAGES C)
+
+` + cmakeNanopbGolden + `
+if(NOT TARGET proto-bar)
+  FetchContent_Declare(proto-bar
+    GIT_REPOSITORY https://github.com/org/proto.git
+    GIT_TAG v1.2.0
+    SOURCE_SUBDIR c/bar)
+  FetchContent_MakeAvailable(proto-bar)
+endif()
+
+add_library(proto-foo INTERFACE)
+target_include_directories(proto-foo INTERFACE ${CMAKE_CURRENT_SOURCE_DIR})
+target_link_libraries(proto-foo INTERFACE nanopb proto-bar)
+`,
+				"library.properties": `name=proto-foo
+version=1.2.0
+author=org
+maintainer=org
+sentence=Protobuf package foo, generated by protodist.
+paragraph=Nanopb messages of protobuf package foo. Requires libraries proto-bar (https://github.com/org/proto.git dir c/bar).
+category=Communication
+url=https://github.com/org/proto.git
+architectures=*
+includes=proto/foo/foo.pb.h
+depends=Nanopb (>=0.4.7)
+`,
+				"library.json": `{
+  "name": "proto-foo",
+  "version": "1.2.0",
+  "description": "Protobuf package foo, generated by protodist",
+  "repository": {
+    "type": "git",
+    "url": "https://github.com/org/proto.git"
+  },
+  "frameworks": "*",
+  "platforms": "*",
+  "dependencies": [
+    {
+      "owner": "nanopb",
+      "name": "Nanopb",
+      "version": "^0.4.7"
+    }
+  ],
+  "build": {
+    "includeDir": ".",
+    "srcDir": "."
+  }
+}
+`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		stagingDir := t.TempDir()
+		for _, name := range test.staged {
+			if err := os.MkdirAll(path.Dir(path.Join(stagingDir, name)), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path.Join(stagingDir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		gitCfg := git.Config{Host: "github.com", Owner: "org", Provider: git.GitHub{}, Ref: "refs/tags/v1.2.0", Monorepo: test.monorepo}
+		writeCManifests(stagingDir, gitCfg, config.C{Nanopb: "0.4.7"}, "foo", []string{"bar"})
+
+		for name, want := range test.want {
+			got, err := ioutil.ReadFile(path.Join(stagingDir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("%s: %s =\n%s\nwant\n%s", test.name, name, got, want)
+			}
+		}
+	}
+}
+
+func TestWriteCManifestsVersion(t *testing.T) {
+	tests := []struct {
+		ref     string
+		version string
+		project string
+	}{
+		{ref: "refs/heads/master", version: "0.0.0", project: "project(proto-foo VERSION 0.0.0 LANGUAGES C)"},
+		{ref: "refs/tags/v1.2.3", version: "1.2.3", project: "project(proto-foo VERSION 1.2.3 LANGUAGES C)"},
+		{ref: "refs/tags/v1.2.0-rc.1", version: "1.2.0-rc.1", project: "project(proto-foo VERSION 1.2.0 LANGUAGES C)"},
+		{ref: "refs/tags/v1.2.0+build.5", version: "1.2.0+build.5", project: "project(proto-foo VERSION 1.2.0 LANGUAGES C)"},
+		{ref: "refs/tags/release", version: "release", project: "project(proto-foo LANGUAGES C)"},
+	}
+
+	for _, test := range tests {
+		stagingDir := t.TempDir()
+		gitCfg := git.Config{Host: "github.com", Owner: "org", Provider: git.GitHub{}, Ref: test.ref}
+		writeCManifests(stagingDir, gitCfg, config.C{Nanopb: "0.4.7"}, "foo", nil)
+
+		cmakeLists, err := ioutil.ReadFile(path.Join(stagingDir, "CMakeLists.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(cmakeLists), "\n"+test.project+"\n") {
+			t.Errorf("%s: CMakeLists.txt doesn't contain %s:\n%s", test.ref, test.project, cmakeLists)
+		}
+		libraryProperties, err := ioutil.ReadFile(path.Join(stagingDir, "library.properties"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(libraryProperties), "\nversion="+test.version+"\n") {
+			t.Errorf("%s: library.properties doesn't contain version %s:\n%s", test.ref, test.version, libraryProperties)
+		}
+	}
+}
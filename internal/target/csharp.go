@@ -0,0 +1,212 @@
+package target
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/4nte/protodist/config"
+	"github.com/4nte/protodist/git"
+	"github.com/4nte/protodist/util"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const CsprojTemplate = `<Project Sdk="Microsoft.NET.Sdk">
+
+  <PropertyGroup>
+    <TargetFramework>netstandard2.0</TargetFramework>
+    <PackageId>{{ .PackageID }}</PackageId>
+    <Version>{{ .Version }}</Version>
+  </PropertyGroup>
+
+  <ItemGroup>
+{{- range .PackageReferences }}
+    <PackageReference Include="{{ .Path }}" Version="{{ .Version }}" />
+{{- end }}
+{{- range .ProjectReferences }}
+    <ProjectReference Include="{{ . }}" />
+{{- end }}
+  </ItemGroup>
+
+</Project>
+`
+
+// Namespace declaration of C# sources
+var csharpNamespaceRegexp = regexp.MustCompile(`(?m)^namespace\s+([A-Za-z_][A-Za-z0-9_.]*)`)
+
+// CSharp distributes packages as .NET projects, with <PackageId>.csproj in the root of a repo dir and
+// generated sources in Generated dir. Family packages are referenced by PackageReference, or by
+// ProjectReference in monorepo, where projects reside next to each other.
+func CSharp(proto config.Proto, gitCfg git.Config, cloneBranch string, cloneDir string, dryRun bool, deployTarget string, deployDir string, csharpCfg config.CSharp) {
+	model := loadModel(proto)
+	csharpPackages := discoverPackages(proto.OutDir, model, "csharp")
+	packageIDs := make(map[string]string)
+	for _, pkg := range csharpPackages {
+		packageIDs[nugetPackageID(pkg)] = pkg
+	}
+
+	// Clone csharp proto repos, monorepo is already cloned
+	if gitCfg.Monorepo == "" {
+		for _, pkg := range csharpPackages {
+			repoName, _ := gitCfg.PackageRepo("csharp", pkg)
+			git.CloneOrCreate(gitCfg, repoName, cloneBranch, dryRun)
+		}
+	}
+
+	// Projects are packed in dependency order, so that dependencies of a project are in the feed when it is restored
+	depResolver := NewDependencyResolver(func(packageID string, requiredPackages []Module) string {
+		fmt.Println("resolving nuget package", packageID)
+		pkg := packageIDs[packageID]
+		repoName, projectDir := gitCfg.PackageRepo("csharp", pkg)
+		version := nugetVersion(gitCfg, pkg)
+
+		var packageReferences []Module
+		var projectReferences []string
+		for _, dep := range requiredPackages {
+			if depPkg, ok := packageIDs[dep.Path]; ok && gitCfg.Monorepo != "" {
+				projectReferences = append(projectReferences, path.Join("..", depPkg, dep.Path+".csproj"))
+				continue
+			}
+			packageReferences = append(packageReferences, dep)
+		}
+		writeCsproj(path.Join(cloneDir, repoName, projectDir, packageID+".csproj"), packageID, version, packageReferences, projectReferences)
+
+		// Monorepo is published once all targets are done
+		if gitCfg.Monorepo == "" {
+			AddCommitTagPublish(gitCfg.Release(repoName, ""), repoName, []string{pkg}, dryRun)
+		}
+		if csharpCfg.FeedDir != "" {
+			packNugetPackage(path.Join(cloneDir, repoName), projectDir, csharpCfg.FeedDir)
+		}
+
+		return version
+	})
+
+	sources := make(map[string]string)
+	for _, pkg := range csharpPackages {
+		repoName, projectDir := gitCfg.PackageRepo("csharp", pkg)
+		srcDir := path.Join(cloneDir, repoName, projectDir, "Generated")
+
+		// Replace sources with generated ones, so that files of deleted protos don't linger
+		if err := os.RemoveAll(srcDir); err != nil {
+			panic(err)
+		}
+		if err := util.CreateIfNotExists(srcDir, 0755); err != nil {
+			panic(err)
+		}
+		if err := util.CopyDirectory(path.Join(proto.OutDir, "csharp", pkg), srcDir); err != nil {
+			panic(err)
+		}
+		sources[pkg] = readSources(srcDir, ".cs")
+	}
+
+	for _, pkg := range csharpPackages {
+		repoName, projectDir := gitCfg.PackageRepo("csharp", pkg)
+		srcDir := path.Join(cloneDir, repoName, projectDir, "Generated")
+
+		thirdPartyDeps := []Module{{Path: "Google.Protobuf", Version: csharpCfg.Protobuf}}
+		if hasFileWithSuffix(srcDir, "Grpc.cs") {
+			thirdPartyDeps = append(thirdPartyDeps, Module{Path: "Grpc.Core.Api", Version: csharpCfg.Grpc})
+		}
+
+		// References to namespaces of other packages are a fallback
+		deps := familyDeps(model, "csharp", pkg, csharpPackages, func() []string {
+			var deps []string
+			for _, dep := range csharpPackages {
+				if dep == pkg {
+					continue
+				}
+				for _, match := range csharpNamespaceRegexp.FindAllStringSubmatch(sources[dep], -1) {
+					if strings.Contains(sources[pkg], "global::"+match[1]+".") {
+						deps = append(deps, dep)
+						break
+					}
+				}
+			}
+			return deps
+		})
+		var depIDs []string
+		for _, dep := range deps {
+			depIDs = append(depIDs, nugetPackageID(dep))
+		}
+
+		depResolver.AddModule(nugetPackageID(pkg), "", depIDs, thirdPartyDeps)
+	}
+
+	depResolver.Resolve()
+}
+
+// nugetPackageID returns NuGet package id of a proto package (e.g Proto.FooBar for foo_bar)
+func nugetPackageID(pkg string) string {
+	return "Proto." + pascalCase(pkg)
+}
+
+// nugetVersion returns NuGet version of a released package, branches are pre-release versions
+func nugetVersion(gitCfg git.Config, pkg string) string {
+	repoName, _ := gitCfg.PackageRepo("csharp", pkg)
+	if refType, _ := gitCfg.Release(repoName, "").ParseRef(); refType != git.TagRef {
+		return releaseVersion(gitCfg, "csharp", pkg) + "-snapshot"
+	}
+
+	return releaseVersion(gitCfg, "csharp", pkg)
+}
+
+func writeCsproj(filename string, packageID string, version string, packageReferences []Module, projectReferences []string) {
+	type CsprojData struct {
+		PackageID         string
+		Version           string
+		PackageReferences []Module
+		ProjectReferences []string
+	}
+
+	tmpl, err := template.New("csproj").Parse(CsprojTemplate)
+	if err != nil {
+		panic(err)
+	}
+	buffer := bytes.NewBuffer(nil)
+	data := CsprojData{PackageID: packageID, Version: version, PackageReferences: packageReferences, ProjectReferences: projectReferences}
+	if err := tmpl.Execute(buffer, data); err != nil {
+		panic(err)
+	}
+
+	if err := ioutil.WriteFile(filename, buffer.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+}
+
+// packNugetPackage packs a project of a cloned repo into a local NuGet folder feed.
+// Family packages are restored from the feed.
+func packNugetPackage(repoDir string, projectDir string, feedDir string) {
+	fmt.Println("packing nuget package", path.Join(repoDir, projectDir))
+	feedDir, err := filepath.Abs(feedDir)
+	if err != nil {
+		panic(err)
+	}
+	if err := os.MkdirAll(feedDir, 0755); err != nil {
+		panic(err)
+	}
+
+	// Build in a copy of the repo, so that build artifacts don't end up in it.
+	// Projects referenced by ProjectReference are in the same repo.
+	buildDir, err := ioutil.TempDir("", "protodist-nuget-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(buildDir)
+	if err := util.CopyDirectory(repoDir, buildDir); err != nil {
+		panic(err)
+	}
+
+	cmd := exec.Command("dotnet", "pack", "--configuration", "Release", "--output", feedDir, "-p:RestoreAdditionalProjectSources="+feedDir)
+	cmd.Dir = path.Join(buildDir, projectDir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		panic(fmt.Errorf("failed to pack nuget package: %s: %s", path.Join(repoDir, projectDir), err))
+	}
+}
@@ -0,0 +1,223 @@
+package target
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/4nte/protodist/config"
+	"github.com/4nte/protodist/git"
+	"github.com/4nte/protodist/util"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const PubspecTemplate = `name: {{ .Name }}
+version: {{ .Version }}
+description: Protobuf package {{ .Package }}, generated by protodist.
+publish_to: none
+
+environment:
+  sdk: '>=2.12.0 <3.0.0'
+
+dependencies:
+  protobuf: {{ .Protobuf }}
+{{- if .Grpc }}
+  grpc: {{ .Grpc }}
+{{- end }}
+{{- range .Dependencies }}
+  {{ .Name }}:
+    git:
+      url: {{ .URL }}
+      ref: {{ .Ref }}
+{{- if .Path }}
+      path: {{ .Path }}
+{{- end }}
+{{- end }}
+`
+
+// Relative imports of protoc-gen-dart output (e.g import '../foo/foo.pb.dart' as $0;)
+var dartImportRegexp = regexp.MustCompile(`((?:import|export)\s+')([^':]+\.dart)(')`)
+
+// Dart distributes packages as pub packages, with generated files in lib/src and a barrel file (lib/proto_<pkg>.dart)
+// exporting them. Relative imports between packages are rewritten into package imports of family packages.
+func Dart(proto config.Proto, gitCfg git.Config, cloneBranch string, cloneDir string, dryRun bool, deployTarget string, deployDir string, dartCfg config.Dart) {
+	model := loadModel(proto)
+	dartPackages := discoverPackages(proto.OutDir, model, "dart")
+
+	// Clone dart proto repos, monorepo is already cloned
+	if gitCfg.Monorepo == "" {
+		for _, pkg := range dartPackages {
+			repoName, _ := gitCfg.PackageRepo("dart", pkg)
+			git.CloneOrCreate(gitCfg, repoName, cloneBranch, dryRun)
+		}
+	}
+
+	for _, pkg := range dartPackages {
+		repoName, pkgDir := gitCfg.PackageRepo("dart", pkg)
+		packageDir := path.Join(cloneDir, repoName, pkgDir)
+		srcDir := path.Join(packageDir, "lib", "src")
+
+		// Replace sources with generated ones, so that files of deleted protos don't linger
+		if err := os.RemoveAll(srcDir); err != nil {
+			panic(err)
+		}
+		if err := util.CreateIfNotExists(srcDir, 0755); err != nil {
+			panic(err)
+		}
+		if err := util.CopyDirectory(path.Join(proto.OutDir, "dart", pkg), srcDir); err != nil {
+			panic(err)
+		}
+
+		importedPackages := rewriteDartImports(srcDir, pkg, dartPackages)
+		writeDartBarrel(path.Join(packageDir, "lib", dartPackage(pkg)+".dart"), srcDir)
+
+		// Imports of generated code are a fallback
+		deps := familyDeps(model, "dart", pkg, dartPackages, func() []string {
+			return importedPackages
+		})
+
+		writePubspec(path.Join(packageDir, "pubspec.yaml"), gitCfg, dartCfg, pkg, deps, hasFileWithSuffix(srcDir, ".pbgrpc.dart"))
+	}
+
+	// Monorepo is published once all targets are done
+	if gitCfg.Monorepo == "" {
+		for _, pkg := range dartPackages {
+			repoName, _ := gitCfg.PackageRepo("dart", pkg)
+			AddCommitTagPublish(gitCfg.Release(repoName, ""), repoName, []string{pkg}, dryRun)
+		}
+	}
+}
+
+// dartPackage returns name of the pub package of a proto package (e.g proto_foo_bar for foo-bar)
+func dartPackage(pkg string) string {
+	return "proto_" + strings.ReplaceAll(strings.ReplaceAll(strings.ToLower(pkg), "-", "_"), ".", "_")
+}
+
+// rewriteDartImports rewrites relative imports of files in other packages into package imports
+// (e.g '../foo/foo.pb.dart' into 'package:proto_foo/src/foo.pb.dart'), imported packages are returned.
+func rewriteDartImports(srcDir string, pkg string, dartPackages []string) []string {
+	var importedPackages []string
+	err := filepath.Walk(srcDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(filePath) != ".dart" {
+			return err
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		relDir, err := filepath.Rel(srcDir, filepath.Dir(filePath))
+		if err != nil {
+			return err
+		}
+
+		rewritten := dartImportRegexp.ReplaceAllStringFunc(string(data), func(statement string) string {
+			match := dartImportRegexp.FindStringSubmatch(statement)
+			// Path of imported file within dart output dir (e.g foo/foo.pb.dart)
+			imported := path.Join(pkg, filepath.ToSlash(relDir), match[2])
+			importedPkg := strings.SplitN(imported, "/", 2)[0]
+			if importedPkg == pkg || !containsString(dartPackages, importedPkg) {
+				return statement
+			}
+			if !containsString(importedPackages, importedPkg) {
+				importedPackages = append(importedPackages, importedPkg)
+			}
+			importPath := fmt.Sprintf("package:%s/src/%s", dartPackage(importedPkg), strings.TrimPrefix(imported, importedPkg+"/"))
+			return match[1] + importPath + match[3]
+		})
+
+		return ioutil.WriteFile(filePath, []byte(rewritten), info.Mode())
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return importedPackages
+}
+
+// writeDartBarrel writes a library which exports messages, enums and grpc stubs of all generated files
+func writeDartBarrel(filename string, srcDir string) {
+	var exports []string
+	err := filepath.Walk(srcDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		for _, suffix := range []string{".pb.dart", ".pbenum.dart", ".pbgrpc.dart"} {
+			if strings.HasSuffix(info.Name(), suffix) {
+				relPath, err := filepath.Rel(path.Dir(filename), filePath)
+				if err != nil {
+					return err
+				}
+				exports = append(exports, filepath.ToSlash(relPath))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	sort.Strings(exports)
+
+	buffer := bytes.NewBufferString("// Code generated by protodist. DO NOT EDIT.\n\n")
+	for _, export := range exports {
+		fmt.Fprintf(buffer, "export '%s';\n", export)
+	}
+	if err := ioutil.WriteFile(filename, buffer.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+}
+
+// writePubspec writes pubspec.yaml of a package, family packages are git dependencies at the released ref
+func writePubspec(filename string, gitCfg git.Config, dartCfg config.Dart, pkg string, deps []string, hasGrpc bool) {
+	type PubspecDependency struct {
+		Name string
+		URL  string
+		Ref  string
+		Path string
+	}
+	type PubspecData struct {
+		Name         string
+		Version      string
+		Package      string
+		Protobuf     string
+		Grpc         string
+		Dependencies []PubspecDependency
+	}
+
+	data := PubspecData{
+		Name:     dartPackage(pkg),
+		Version:  releaseVersion(gitCfg, "dart", pkg),
+		Package:  pkg,
+		Protobuf: dartCfg.Protobuf,
+	}
+	if hasGrpc {
+		data.Grpc = dartCfg.Grpc
+	}
+	for _, dep := range deps {
+		depRepo, depDir := gitCfg.PackageRepo("dart", dep)
+		_, refName := gitCfg.Release(depRepo, "").ParseRef()
+		data.Dependencies = append(data.Dependencies, PubspecDependency{
+			Name: dartPackage(dep),
+			URL:  gitCfg.PackageURL(depRepo),
+			Ref:  refName,
+			Path: depDir,
+		})
+	}
+
+	tmpl, err := template.New("pubspec").Parse(PubspecTemplate)
+	if err != nil {
+		panic(err)
+	}
+	buffer := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(buffer, data); err != nil {
+		panic(err)
+	}
+
+	if err := ioutil.WriteFile(filename, buffer.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+}
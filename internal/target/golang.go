@@ -3,17 +3,22 @@ package target
 import (
 	"bytes"
 	"fmt"
+	"github.com/4nte/protodist/config"
 	"github.com/4nte/protodist/git"
+	"github.com/4nte/protodist/internal/descriptor"
+	"github.com/4nte/protodist/internal/release"
 	"github.com/4nte/protodist/util"
 	"github.com/pkg/errors"
 	"go/parser"
 	"go/token"
 	"golang.org/x/tools/go/packages"
+	"google.golang.org/protobuf/types/descriptorpb"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"text/template"
 )
@@ -167,38 +172,57 @@ func (r DependencyResolver) Resolve() {
 		}
 	}
 }
-func Golang(protoOutDir string, gitCfg git.Config, cloneBranch string, cloneDir string, dryRun bool, deployTarget string, deployDir string) {
+func Golang(proto config.Proto, gitCfg git.Config, cloneBranch string, cloneDir string, dryRun bool, deployTarget string, deployDir string) {
 	var protoModules []string // Currently compiled proto modules
+	// Repo and a dir within the repo of each module
+	type moduleLocation struct {
+		Pkg  string
+		Repo string
+		Dir  string
+	}
+	moduleLocations := make(map[string]moduleLocation)
 	loadStandardPackages()
-	var goPackages []string
-	// Scan compiled go packages
-	files, err := ioutil.ReadDir(path.Join(protoOutDir, "go"))
-	if err != nil {
-		log.Fatal(err)
+
+	// Descriptor set drives package discovery and dependencies between proto modules
+	var descriptorSet *descriptorpb.FileDescriptorSet
+	var model *descriptor.Model
+	if proto.DescriptorSet != "" {
+		var err error
+		descriptorSet, err = descriptor.Load(proto.DescriptorSet)
+		if err != nil {
+			panic(err)
+		}
+		model = descriptor.NewModel(descriptorSet, descriptor.HasGeneratedCode(proto.OutDir))
 	}
+	var goPackages []string
 
 	//_, refName := gitCfg.ParseRef()
-	for _, f := range files {
-		if !f.IsDir() {
-			panic("file is not expected to be here, only directories")
-		}
-		pkgName := f.Name()
+	for _, pkgName := range discoverPackages(proto.OutDir, model, "go") {
 		goPackages = append(goPackages, pkgName)
 
 		// Add proto module
-		modulePath := fmt.Sprintf("%s/proto-%s-go", gitCfg.GitBase(), pkgName)
+		repoName, moduleDir := gitCfg.PackageRepo("go", pkgName)
+		modulePath := goModulePath(gitCfg, repoName, moduleDir)
+		if model != nil {
+			basePath := path.Join(gitCfg.GitBase(), repoName, moduleDir)
+			if err := checkGoMajorSuffix(pkgName, basePath, modulePath, model.Packages[pkgName].GoPackages()); err != nil {
+				panic(err)
+			}
+		}
 		protoModules = append(protoModules, modulePath)
+		moduleLocations[modulePath] = moduleLocation{Pkg: pkgName, Repo: repoName, Dir: moduleDir}
 
 		//fmt.Println(f.Path())
 	}
 	//defer os.RemoveAll(cloneDir)
 
-	// Clone go proto repos
+	// Clone go proto repos, monorepo is already cloned
 	for _, pkg := range goPackages {
-		repoName := fmt.Sprintf("proto-%s-go", pkg)
-		if deployTarget == "git" {
-			repoUrl := gitCfg.GetRepoURL(repoName)
-			git.Clone(repoUrl, cloneBranch)
+		repoName, _ := gitCfg.PackageRepo("go", pkg)
+		if deployTarget == "git" && gitCfg.Monorepo != "" {
+			continue
+		} else if deployTarget == "git" {
+			git.CloneOrCreate(gitCfg, repoName, cloneBranch, dryRun)
 		} else if deployTarget == "local" {
 			err := os.Mkdir(path.Join(os.TempDir(), repoName), 0755)
 			if err != nil {
@@ -229,9 +253,9 @@ func Golang(protoOutDir string, gitCfg git.Config, cloneBranch string, cloneDir
 			panic(err)
 		}
 
-		fragments := strings.SplitAfter(modulePath, "/")
-		repoName := fragments[len(fragments)-1]
-		f, err := os.OpenFile(path.Join(cloneDir, repoName, "go.mod"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+		location := moduleLocations[modulePath]
+		repoName := location.Repo
+		f, err := os.OpenFile(path.Join(cloneDir, repoName, location.Dir, "go.mod"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -249,14 +273,22 @@ func Golang(protoOutDir string, gitCfg git.Config, cloneBranch string, cloneDir
 
 		if deployTarget == "git" {
 			git.AddAll(repoName)
-			commit := git.Commit(repoName, "add pb files")
-			refType, refName := gitCfg.ParseRef()
+			var commit git.CommitInfo
+			if git.HasStagedChanges(repoName) {
+				commit = git.Commit(repoName, "add pb files")
+			} else {
+				commit = git.LastCommit(repoName)
+			}
+			// Module may have its own release version
+			moduleCfg := gitCfg.Release(repoName, location.Dir)
+			refType, refName := moduleCfg.ParseRef()
 			if refType == git.TagRef {
-				// Create a git tag
-				git.Tag(repoName, refName)
+				// Create a git tag, modules in monorepo subdirectories are tagged with a path prefix (e.g go/foo/v1.0.0)
+				git.Tag(repoName, path.Join(location.Dir, refName))
 			}
-			if !dryRun {
-				git.Push(repoName, refName)
+			// Monorepo is published once all targets are done
+			if !dryRun && gitCfg.Monorepo == "" {
+				git.Publish(moduleCfg, repoName, []string{location.Pkg})
 			}
 
 			switch refType {
@@ -286,9 +318,12 @@ func Golang(protoOutDir string, gitCfg git.Config, cloneBranch string, cloneDir
 	})
 
 	for _, pkg := range goPackages {
-		modulePath := fmt.Sprintf("%s/proto-%s-go", gitCfg.GitBase(), pkg)
-		repoName := fmt.Sprintf("proto-%s-go", pkg)
-		repoDir := path.Join(cloneDir, repoName)
+		repoName, moduleDir := gitCfg.PackageRepo("go", pkg)
+		modulePath := goModulePath(gitCfg, repoName, moduleDir)
+		repoDir := path.Join(cloneDir, repoName, moduleDir)
+		if err := util.CreateIfNotExists(repoDir, 0755); err != nil {
+			panic(err)
+		}
 		pkgCloneDir, err := ioutil.ReadDir(repoDir)
 		if err != nil {
 			panic(err)
@@ -307,12 +342,23 @@ func Golang(protoOutDir string, gitCfg git.Config, cloneBranch string, cloneDir
 		}
 
 		// Move generate .go files to cloned repo dir
-		generatedPkgDir := path.Join(protoOutDir, "go", pkg)
+		generatedPkgDir := path.Join(proto.OutDir, "go", pkg)
 		err = util.CopyDirectory(generatedPkgDir, repoDir)
 		if err != nil {
 			panic(err)
 		}
 
+		// Descriptor set of the package is published along with it, next release is compared against it
+		if descriptorSet != nil {
+			data, err := descriptor.Marshal(descriptor.PackageSet(descriptorSet, pkg))
+			if err != nil {
+				panic(err)
+			}
+			if err := ioutil.WriteFile(path.Join(repoDir, release.ProtosetFile), data, 0644); err != nil {
+				panic(err)
+			}
+		}
+
 		// Generate go.mod file for Module
 		var importedPackages []string
 		entries, err := ioutil.ReadDir(generatedPkgDir)
@@ -356,7 +402,7 @@ func Golang(protoOutDir string, gitCfg git.Config, cloneBranch string, cloneDir
 			var isFound bool
 			// Test if pkg is a family member proto module
 			for _, module := range protoModules {
-				if strings.HasPrefix(importedPkg, module) {
+				if importedPkg == module || strings.HasPrefix(importedPkg, module+"/") {
 					isFound = true
 					// Check if pkg was already added to required proto packages
 					var isAlreadyAdded bool
@@ -397,12 +443,53 @@ func Golang(protoOutDir string, gitCfg git.Config, cloneBranch string, cloneDir
 			}
 
 			if !isFound {
+				// Import of a v2+ module without its major version suffix is reported, rather than left unresolved
+				for _, module := range protoModules {
+					location := moduleLocations[module]
+					basePath := path.Join(gitCfg.GitBase(), location.Repo, location.Dir)
+					if err := checkGoMajorSuffix(location.Pkg, basePath, module, []string{importedPkg}); err != nil {
+						panic(err)
+					}
+				}
 				// Package not identified, I don't know what to do with it.
 				unknownPackages = append(unknownPackages, importedPkg)
 			}
 
 		}
 
+		// Imports of generated code are just a fallback, dependencies between proto modules are known precisely from descriptor set
+		if model != nil {
+			importedProtoPackages := requiredProtoPackages
+			requiredProtoPackages = nil
+			for _, dep := range model.Packages[pkg].Dependencies {
+				depRepo, depDir := gitCfg.PackageRepo("go", dep)
+				depModule := goModulePath(gitCfg, depRepo, depDir)
+				if _, ok := moduleLocations[depModule]; !ok {
+					panic(fmt.Sprintf("package %s depends on %s, which has no Go output", pkg, dep))
+				}
+				requiredProtoPackages = append(requiredProtoPackages, depModule)
+			}
+			// Generated code out of sync with descriptor set would fail to build without its imports
+			for _, importedModule := range importedProtoPackages {
+				var isRequired bool
+				for _, requiredModule := range requiredProtoPackages {
+					if importedModule == requiredModule {
+						isRequired = true
+					}
+				}
+				if !isRequired {
+					fmt.Printf("warning: package %s imports %s, which is not its dependency in descriptor set\n", pkg, importedModule)
+					requiredProtoPackages = append(requiredProtoPackages, importedModule)
+				}
+			}
+
+			for _, goPackage := range model.Packages[pkg].GoPackages() {
+				if goPackage != modulePath && !strings.HasPrefix(goPackage, modulePath+"/") {
+					fmt.Printf("warning: go_package %s of package %s is not within module %s\n", goPackage, pkg, modulePath)
+				}
+			}
+		}
+
 		if len(unknownPackages) > 0 {
 			for _, unresolvedPackage := range unknownPackages {
 				fmt.Printf("failed to resolve package %s\n", unresolvedPackage)
@@ -421,3 +508,42 @@ func Golang(protoOutDir string, gitCfg git.Config, cloneBranch string, cloneDir
 	depResolver.Resolve()
 
 }
+
+// goModulePath returns path of a Go module in a repo dir. Modules released as v2 or later have a major version
+// suffix (e.g /v2), as required by Go, so go_package options of their protos must have the suffix as well.
+func goModulePath(gitCfg git.Config, repoName string, moduleDir string) string {
+	modulePath := path.Join(gitCfg.GitBase(), repoName, moduleDir)
+	refType, refName := gitCfg.Release(repoName, moduleDir).ParseRef()
+	if refType != git.TagRef {
+		return modulePath
+	}
+
+	major, err := strconv.Atoi(strings.SplitN(strings.TrimPrefix(refName, "v"), ".", 2)[0])
+	if err == nil && major >= 2 {
+		modulePath = fmt.Sprintf("%s/v%d", modulePath, major)
+	}
+
+	return modulePath
+}
+
+// checkGoMajorSuffix fails when a package released as v2 or later is imported by a path without the major version
+// suffix of its module path (e.g go_package of its protos lacks /v2), such imports can't be resolved to the module.
+func checkGoMajorSuffix(pkg string, basePath string, modulePath string, importPaths []string) error {
+	if modulePath == basePath {
+		return nil
+	}
+
+	for _, importPath := range importPaths {
+		if isWithinGoModule(importPath, basePath) && !isWithinGoModule(importPath, modulePath) {
+			suffix := strings.TrimPrefix(modulePath, basePath)
+			return fmt.Errorf("package %s is released as Go module %s, but it is imported as %s: add %s suffix to go_package options of package %s (e.g %s)",
+				pkg, modulePath, importPath, suffix, pkg, modulePath+strings.TrimPrefix(importPath, basePath))
+		}
+	}
+
+	return nil
+}
+
+func isWithinGoModule(importPath string, modulePath string) bool {
+	return importPath == modulePath || strings.HasPrefix(importPath, modulePath+"/")
+}
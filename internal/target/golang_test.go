@@ -0,0 +1,88 @@
+package target
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/4nte/protodist/git"
+)
+
+func TestGoModulePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		monorepo string
+		versions map[string]string
+		want     string
+	}{
+		{
+			name: "branch",
+			want: "github.com/org/proto-foo-go",
+		},
+		{
+			name:     "v1 release",
+			versions: map[string]string{"proto-foo-go": "v1.4.0"},
+			want:     "github.com/org/proto-foo-go",
+		},
+		{
+			name:     "v2 release",
+			versions: map[string]string{"proto-foo-go": "v2.0.0"},
+			want:     "github.com/org/proto-foo-go/v2",
+		},
+		{
+			name:     "v3 release of monorepo module",
+			monorepo: "proto",
+			versions: map[string]string{"proto/go/foo": "v3.1.0"},
+			want:     "github.com/org/proto/go/foo/v3",
+		},
+	}
+
+	for _, test := range tests {
+		cfg := git.Config{Host: "github.com", Owner: "org", Ref: "refs/heads/master", Monorepo: test.monorepo, Versions: test.versions}
+		repoName, moduleDir := cfg.PackageRepo("go", "foo")
+		if got := goModulePath(cfg, repoName, moduleDir); got != test.want {
+			t.Errorf("%s: goModulePath() = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckGoMajorSuffix(t *testing.T) {
+	basePath := "github.com/org/proto-foo-go"
+	tests := []struct {
+		name        string
+		modulePath  string
+		importPaths []string
+		wantErr     string
+	}{
+		{
+			name:        "v1 module",
+			modulePath:  basePath,
+			importPaths: []string{basePath, basePath + "/sub"},
+		},
+		{
+			name:        "v2 module imported with suffix",
+			modulePath:  basePath + "/v2",
+			importPaths: []string{basePath + "/v2", basePath + "/v2/sub"},
+		},
+		{
+			name:        "v2 module imported without suffix",
+			modulePath:  basePath + "/v2",
+			importPaths: []string{basePath + "/v2", basePath + "/sub"},
+			wantErr:     "add /v2 suffix to go_package options of package foo (e.g github.com/org/proto-foo-go/v2/sub)",
+		},
+		{
+			name:        "v2 module and imports of other modules",
+			modulePath:  basePath + "/v2",
+			importPaths: []string{"github.com/org/proto-foo-go-extra", "github.com/org/proto-bar-go"},
+		},
+	}
+
+	for _, test := range tests {
+		err := checkGoMajorSuffix("foo", basePath, test.modulePath, test.importPaths)
+		if test.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+			t.Errorf("%s: error = %v, want %s", test.name, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package target
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/4nte/protodist/config"
+	"github.com/4nte/protodist/git"
+	"github.com/4nte/protodist/util"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const PomTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<project xmlns="http://maven.apache.org/POM/4.0.0" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://maven.apache.org/POM/4.0.0 http://maven.apache.org/xsd/maven-4.0.0.xsd">
+  <modelVersion>4.0.0</modelVersion>
+
+  <groupId>{{ .GroupID }}</groupId>
+  <artifactId>{{ .ArtifactID }}</artifactId>
+  <version>{{ .Version }}</version>
+  <packaging>jar</packaging>
+
+  <properties>
+    <project.build.sourceEncoding>UTF-8</project.build.sourceEncoding>
+    <maven.compiler.source>1.8</maven.compiler.source>
+    <maven.compiler.target>1.8</maven.compiler.target>
+  </properties>
+
+  <dependencies>
+{{- range .Dependencies }}
+    <dependency>
+      <groupId>{{ .GroupID }}</groupId>
+      <artifactId>{{ .ArtifactID }}</artifactId>
+      <version>{{ .Version }}</version>
+{{- if .Scope }}
+      <scope>{{ .Scope }}</scope>
+{{- end }}
+    </dependency>
+{{- end }}
+  </dependencies>
+{{- if .Kotlin }}
+
+  <build>
+    <plugins>
+      <plugin>
+        <groupId>org.jetbrains.kotlin</groupId>
+        <artifactId>kotlin-maven-plugin</artifactId>
+        <version>{{ .Kotlin }}</version>
+        <executions>
+          <execution>
+            <id>compile</id>
+            <goals>
+              <goal>compile</goal>
+            </goals>
+            <configuration>
+              <sourceDirs>
+                <sourceDir>${project.basedir}/src/main/java</sourceDir>
+              </sourceDirs>
+            </configuration>
+          </execution>
+        </executions>
+      </plugin>
+    </plugins>
+  </build>
+{{- end }}
+</project>
+`
+
+// Java package declaration of java and kotlin sources
+var javaPackageRegexp = regexp.MustCompile(`(?m)^package\s+([A-Za-z_][A-Za-z0-9_.]*)`)
+
+// Scopes of third party dependencies, compile scope is used for the rest
+var mavenScopes = map[string]string{
+	// javax.annotation.Generated of grpc stubs is not a part of JDK 9+
+	"org.apache.tomcat:annotations-api": "provided",
+}
+
+// Java distributes packages as maven projects, with pom.xml in the root of a repo dir and
+// generated java (and kotlin) sources in src/main/java. Dependencies are maven coordinates (<groupId>:<artifactId>).
+func Java(proto config.Proto, gitCfg git.Config, cloneBranch string, cloneDir string, dryRun bool, deployTarget string, deployDir string, javaCfg config.Java) {
+	model := loadModel(proto)
+	javaPackages := discoverPackages(proto.OutDir, model, "java")
+	artifactPackages := make(map[string]string)
+	for _, pkg := range javaPackages {
+		artifactPackages[mavenCoordinates(gitCfg, javaCfg, pkg)] = pkg
+	}
+
+	// Clone java proto repos, monorepo is already cloned
+	if gitCfg.Monorepo == "" {
+		for _, pkg := range javaPackages {
+			repoName, _ := gitCfg.PackageRepo("java", pkg)
+			git.CloneOrCreate(gitCfg, repoName, cloneBranch, dryRun)
+		}
+	}
+
+	// Projects are deployed in dependency order, so that dependencies of a project are installed when it is built
+	depResolver := NewDependencyResolver(func(coordinates string, requiredPackages []Module) string {
+		fmt.Println("resolving artifact", coordinates)
+		pkg := artifactPackages[coordinates]
+		repoName, projectDir := gitCfg.PackageRepo("java", pkg)
+		version := javaVersion(gitCfg, pkg)
+
+		writePom(path.Join(cloneDir, repoName, projectDir, "pom.xml"), coordinates, version, requiredPackages, javaCfg)
+
+		// Monorepo is published once all targets are done
+		if gitCfg.Monorepo == "" {
+			AddCommitTagPublish(gitCfg.Release(repoName, ""), repoName, []string{pkg}, dryRun)
+		}
+		if javaCfg.RepositoryDir != "" {
+			deployMavenProject(path.Join(cloneDir, repoName, projectDir), javaCfg.RepositoryDir)
+		}
+
+		return version
+	})
+
+	sources := make(map[string]string)
+	for _, pkg := range javaPackages {
+		repoName, projectDir := gitCfg.PackageRepo("java", pkg)
+		srcDir := path.Join(cloneDir, repoName, projectDir, "src", "main", "java")
+
+		// Replace sources with generated ones, so that files of deleted protos don't linger
+		if err := os.RemoveAll(srcDir); err != nil {
+			panic(err)
+		}
+		if err := util.CreateIfNotExists(srcDir, 0755); err != nil {
+			panic(err)
+		}
+		if err := util.CopyDirectory(path.Join(proto.OutDir, "java", pkg), srcDir); err != nil {
+			panic(err)
+		}
+		sources[pkg] = readSources(srcDir, ".java", ".kt")
+	}
+
+	for _, pkg := range javaPackages {
+		repoName, projectDir := gitCfg.PackageRepo("java", pkg)
+		srcDir := path.Join(cloneDir, repoName, projectDir, "src", "main", "java")
+
+		thirdPartyDeps := []Module{{Path: "com.google.protobuf:protobuf-java", Version: javaCfg.Protobuf}}
+		if hasFileWithSuffix(srcDir, "Grpc.java") {
+			thirdPartyDeps = append(thirdPartyDeps,
+				Module{Path: "io.grpc:grpc-protobuf", Version: javaCfg.Grpc},
+				Module{Path: "io.grpc:grpc-stub", Version: javaCfg.Grpc},
+				Module{Path: "org.apache.tomcat:annotations-api", Version: "6.0.53"},
+			)
+		}
+		if hasFileWithSuffix(srcDir, ".kt") {
+			thirdPartyDeps = append(thirdPartyDeps,
+				Module{Path: "com.google.protobuf:protobuf-kotlin", Version: javaCfg.Protobuf},
+				Module{Path: "org.jetbrains.kotlin:kotlin-stdlib", Version: javaCfg.Kotlin},
+			)
+		}
+
+		// References to java packages of other projects are a fallback
+		deps := familyDeps(model, "java", pkg, javaPackages, func() []string {
+			var deps []string
+			for _, dep := range javaPackages {
+				if dep == pkg {
+					continue
+				}
+				for _, match := range javaPackageRegexp.FindAllStringSubmatch(sources[dep], -1) {
+					if strings.Contains(sources[pkg], match[1]+".") {
+						deps = append(deps, dep)
+						break
+					}
+				}
+			}
+			return deps
+		})
+		var depCoordinates []string
+		for _, dep := range deps {
+			depCoordinates = append(depCoordinates, mavenCoordinates(gitCfg, javaCfg, dep))
+		}
+
+		depResolver.AddModule(mavenCoordinates(gitCfg, javaCfg, pkg), "", depCoordinates, thirdPartyDeps)
+	}
+
+	depResolver.Resolve()
+}
+
+// mavenCoordinates returns <groupId>:<artifactId> of a package, rendered from templates of java config
+func mavenCoordinates(gitCfg git.Config, javaCfg config.Java, pkg string) string {
+	data := struct {
+		Host    string
+		Owner   string
+		Package string
+	}{Host: gitCfg.Host, Owner: gitCfg.Owner, Package: pkg}
+
+	render := func(name string, text string) string {
+		tmpl, err := template.New(name).Parse(text)
+		if err != nil {
+			panic(fmt.Errorf("invalid %s template: %s", name, err))
+		}
+		buffer := bytes.NewBuffer(nil)
+		if err := tmpl.Execute(buffer, data); err != nil {
+			panic(err)
+		}
+		return buffer.String()
+	}
+
+	return render("groupId", javaCfg.GroupID) + ":" + render("artifactId", javaCfg.ArtifactID)
+}
+
+// javaVersion returns maven version of a released package, branches are SNAPSHOT versions
+func javaVersion(gitCfg git.Config, pkg string) string {
+	repoName, _ := gitCfg.PackageRepo("java", pkg)
+	if refType, _ := gitCfg.Release(repoName, "").ParseRef(); refType != git.TagRef {
+		return releaseVersion(gitCfg, "java", pkg) + "-SNAPSHOT"
+	}
+
+	return releaseVersion(gitCfg, "java", pkg)
+}
+
+func writePom(filename string, coordinates string, version string, requiredPackages []Module, javaCfg config.Java) {
+	type PomDependency struct {
+		GroupID    string
+		ArtifactID string
+		Version    string
+		Scope      string
+	}
+	type PomData struct {
+		GroupID      string
+		ArtifactID   string
+		Version      string
+		Dependencies []PomDependency
+		Kotlin       string
+	}
+
+	coords := strings.SplitN(coordinates, ":", 2)
+	data := PomData{GroupID: coords[0], ArtifactID: coords[1], Version: version}
+	for _, dep := range requiredPackages {
+		depCoords := strings.SplitN(dep.Path, ":", 2)
+		data.Dependencies = append(data.Dependencies, PomDependency{
+			GroupID:    depCoords[0],
+			ArtifactID: depCoords[1],
+			Version:    dep.Version,
+			Scope:      mavenScopes[dep.Path],
+		})
+		if dep.Path == "org.jetbrains.kotlin:kotlin-stdlib" {
+			data.Kotlin = javaCfg.Kotlin
+		}
+	}
+
+	tmpl, err := template.New("pom").Parse(PomTemplate)
+	if err != nil {
+		panic(err)
+	}
+	buffer := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(buffer, data); err != nil {
+		panic(err)
+	}
+
+	if err := ioutil.WriteFile(filename, buffer.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+}
+
+// deployMavenProject builds a jar of a maven project and deploys it along with its pom into a file based maven repository
+func deployMavenProject(projectDir string, repositoryDir string) {
+	fmt.Println("deploying maven project", projectDir)
+	repositoryDir, err := filepath.Abs(repositoryDir)
+	if err != nil {
+		panic(err)
+	}
+
+	// Build in a copy of the project, so that build artifacts don't end up in the repo
+	buildDir, err := ioutil.TempDir("", "protodist-maven-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(buildDir)
+	if err := util.CopyDirectory(projectDir, buildDir); err != nil {
+		panic(err)
+	}
+
+	cmd := exec.Command("mvn", "--batch-mode", "deploy", "-DaltDeploymentRepository=protodist::default::file://"+repositoryDir)
+	cmd.Dir = buildDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		panic(fmt.Errorf("failed to deploy maven project: %s: %s", projectDir, err))
+	}
+}
@@ -1,48 +1,443 @@
 package target
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/4nte/protodist/config"
 	"github.com/4nte/protodist/git"
 	"github.com/4nte/protodist/util"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
 )
 
-func Javascript(protoOutDir string, gitCfg git.Config, cloneBranch string, cloneDir string, dryRun bool, deployTarget string, deployDir string) {
+// Runtime packages imported by generated code of protoc-gen-ts, grpc-web and ts-proto
+var knownJSPackages = []Module{
+	{Path: "google-protobuf", Version: "^3.14.0"},
+	{Path: "@grpc/grpc-js", Version: "^1.2.0"},
+	{Path: "grpc-web", Version: "^1.2.0"},
+	{Path: "protobufjs", Version: "^6.10.0"},
+	{Path: "long", Version: "^4.0.0"},
+}
+
+// Hand-maintained fields merged into generated package.json
+const packageJSONOverrideFile = "package.override.json"
+
+// Module specifiers of import statements and require calls
+var jsImportRegexp = regexp.MustCompile(`(?:\bfrom|\bimport|\brequire\()\s*['"]([^'"]+)['"]`)
+
+// PackageJSON is package.json of a distributed npm package
+type PackageJSON struct {
+	Name          string                         `json:"name"`
+	Version       string                         `json:"version"`
+	Description   string                         `json:"description,omitempty"`
+	Main          string                         `json:"main,omitempty"`
+	Types         string                         `json:"types,omitempty"`
+	Files         []string                       `json:"files,omitempty"`
+	Exports       map[string]ExportConditions    `json:"exports,omitempty"`
+	TypesVersions map[string]map[string][]string `json:"typesVersions,omitempty"`
+	Dependencies  map[string]string              `json:"dependencies,omitempty"`
+}
+
+// ExportConditions of a package.json export, conditions are matched in order of fields
+type ExportConditions struct {
+	Types   string `json:"types,omitempty"`
+	Import  string `json:"import,omitempty"`
+	Require string `json:"require,omitempty"`
+	Default string `json:"default,omitempty"`
+}
+
+// Javascript distributes all packages through a single npm package, or each package through its own npm package
+// when jsCfg.PerPackage is set.
+func Javascript(proto config.Proto, gitCfg git.Config, cloneBranch string, cloneDir string, dryRun bool, deployTarget string, deployDir string, jsCfg config.Javascript) {
+	if jsCfg.PerPackage {
+		javascriptPackages(proto, gitCfg, cloneBranch, cloneDir, dryRun, jsCfg)
+		return
+	}
+
 	var tsPackages []string
 
+	// All packages are distributed through a single repo, or reside in js directory of monorepo
 	repoName := "proto-all-js"
-	repoUrl := gitCfg.GetRepoURL(repoName)
-	git.Clone(repoUrl, cloneBranch)
+	repoPkgsDir := ""
+	if gitCfg.Monorepo != "" {
+		repoName = gitCfg.Monorepo
+		repoPkgsDir = "js"
+	} else {
+		git.CloneOrCreate(gitCfg, repoName, cloneBranch, dryRun)
+	}
+
+	tsPackages = discoverPackages(proto.OutDir, loadModel(proto), "ts")
 
-	packageDirs, err := ioutil.ReadDir(path.Join(protoOutDir, "ts"))
+	// Generated pb files of all packages are staged, so that files of deleted protos and packages are removed from repo
+	stagingDir, err := ioutil.TempDir("", "protodist-js-*")
 	if err != nil {
-		log.Fatal(err)
+		panic(err)
 	}
-	for _, pkgDir := range packageDirs {
-		if pkgDir.IsDir() {
-			tsPackages = append(tsPackages, pkgDir.Name())
-		} else {
-			// Log this, don't panic
-			//panic("file is not expected to be here, only directories")
+	defer os.RemoveAll(stagingDir)
+	for _, pkg := range tsPackages {
+		pkgStagingDir := path.Join(stagingDir, pkg)
+		if err := os.MkdirAll(pkgStagingDir, 0700); err != nil {
+			panic(fmt.Errorf("failed to create dir for package: %s", err))
+		}
+		err := util.CopyDirectory(path.Join(proto.OutDir, "ts", pkg), pkgStagingDir)
+		if err != nil {
+			panic(err)
 		}
 	}
+	if err := util.SyncDirectory(stagingDir, path.Join(cloneDir, repoName, repoPkgsDir), jsCfg.Protected); err != nil {
+		panic(err)
+	}
 
-	// Copy generated pb files to repo dirs
+	// Package.json makes the repo (or js dir of monorepo) an npm package, with a subpath export per proto package
+	packageJSON := PackageJSON{
+		Name:         npmPackageName(jsCfg.Scope, jsCfg.Name),
+		Version:      repoVersion(gitCfg, repoName),
+		Description:  "Protobuf packages generated by protodist",
+		Dependencies: make(map[string]string),
+	}
+	var compiler string
+	if jsCfg.Compile {
+		compiler = typeScriptCompiler()
+	}
+	// Each package has an index, index of the npm package exports them as namespaces
+	packageIndexes := make(map[string]string)
+	isCommonJS := true
 	for _, pkg := range tsPackages {
-		pkgTargetDir := path.Join(cloneDir, repoName, pkg)
-		if err := os.MkdirAll(pkgTargetDir, 0700); err != nil {
-			panic(fmt.Errorf("failed to create dir for package: %s", err))
+		pkgDir := path.Join(cloneDir, repoName, repoPkgsDir, pkg)
+		addPackageExports(&packageJSON, pkg, compiler)
+		addJSDependencies(packageJSON.Dependencies, pkgDir, pkg)
+		if indexFile, commonJS := writePackageIndex(pkgDir, pkg); indexFile != "" {
+			packageIndexes[pkg] = indexFile
+			isCommonJS = isCommonJS && commonJS
+			addIndexExport(&packageJSON, pkg, path.Join(pkg, path.Base(indexFile)), compiler)
+		}
+	}
+	if len(packageIndexes) > 0 {
+		indexFile := writeRootIndex(path.Join(cloneDir, repoName, repoPkgsDir), packageIndexes, isCommonJS)
+		addIndexExport(&packageJSON, "", path.Base(indexFile), compiler)
+	}
+	writePackageJSON(path.Join(cloneDir, repoName, repoPkgsDir, "package.json"), packageJSON)
+	if jsCfg.Compile {
+		compileTypeScript(compiler, path.Join(cloneDir, repoName, repoPkgsDir), packageJSON, nil)
+	}
+
+	// Add to GIT, monorepo is published once all targets are done
+	if gitCfg.Monorepo == "" {
+		AddCommitTagPublish(gitCfg.Release(repoName, ""), repoName, tsPackages, dryRun)
+	}
+	if gitCfg.Monorepo == "" {
+		PublishJavascript(proto, gitCfg, cloneDir, dryRun, jsCfg)
+	}
+}
+
+// javascriptPackages distributes each package as an npm package, with generated modules in the root of a repo dir.
+// Relative imports of other packages are rewritten into imports of their npm packages, which become dependencies.
+func javascriptPackages(proto config.Proto, gitCfg git.Config, cloneBranch string, cloneDir string, dryRun bool, jsCfg config.Javascript) {
+	tsPackages := discoverPackages(proto.OutDir, loadModel(proto), "ts")
+
+	// Clone js proto repos, monorepo is already cloned
+	if gitCfg.Monorepo == "" {
+		for _, pkg := range tsPackages {
+			repoName, _ := gitCfg.PackageRepo("js", pkg)
+			git.CloneOrCreate(gitCfg, repoName, cloneBranch, dryRun)
+		}
+	}
+
+	var compiler string
+	if jsCfg.Compile {
+		compiler = typeScriptCompiler()
+	}
+
+	// Packages are compiled in dependency order, so that declarations of family packages exist when a package is type checked
+	packageJSONs := make(map[string]PackageJSON)
+	depResolver := NewDependencyResolver(func(pkg string, requiredPackages []Module) string {
+		familyPackageDirs := make(map[string]string)
+		for _, dep := range requiredPackages {
+			depRepo, depDir := gitCfg.PackageRepo("js", dep.Path)
+			familyPackageDirs[npmPackageName(jsCfg.Scope, "proto-"+dep.Path)] = path.Join(cloneDir, depRepo, depDir)
+		}
+		repoName, pkgDir := gitCfg.PackageRepo("js", pkg)
+		compileTypeScript(compiler, path.Join(cloneDir, repoName, pkgDir), packageJSONs[pkg], familyPackageDirs)
+		return packageJSONs[pkg].Version
+	})
+
+	for _, pkg := range tsPackages {
+		repoName, pkgDir := gitCfg.PackageRepo("js", pkg)
+		repoDir := path.Join(cloneDir, repoName, pkgDir)
+		if err := util.SyncDirectory(path.Join(proto.OutDir, "ts", pkg), repoDir, jsCfg.Protected); err != nil {
+			panic(err)
+		}
+
+		packageJSON := PackageJSON{
+			Name:         npmPackageName(jsCfg.Scope, "proto-"+pkg),
+			Version:      releaseVersion(gitCfg, "js", pkg),
+			Description:  fmt.Sprintf("Protobuf package %s generated by protodist", pkg),
+			Dependencies: make(map[string]string),
+		}
+		addPackageExports(&packageJSON, "", compiler)
+		addJSDependencies(packageJSON.Dependencies, repoDir, pkg)
+		familyDeps := rewriteJSImports(repoDir, pkg, tsPackages, jsCfg)
+		for _, dep := range familyDeps {
+			packageJSON.Dependencies[npmPackageName(jsCfg.Scope, "proto-"+dep)] = npmDependencyVersion(gitCfg, jsCfg, dep)
+		}
+		if indexFile, _ := writePackageIndex(repoDir, pkg); indexFile != "" {
+			addIndexExport(&packageJSON, "", path.Base(indexFile), compiler)
+		}
+		writePackageJSON(path.Join(repoDir, "package.json"), packageJSON)
+		packageJSONs[pkg] = packageJSON
+		depResolver.AddModule(pkg, "", familyDeps, nil)
+	}
+	if jsCfg.Compile {
+		depResolver.Resolve()
+	}
+
+	// Monorepo is published once all targets are done
+	if gitCfg.Monorepo == "" {
+		for _, pkg := range tsPackages {
+			repoName, _ := gitCfg.PackageRepo("js", pkg)
+			AddCommitTagPublish(gitCfg.Release(repoName, ""), repoName, []string{pkg}, dryRun)
+		}
+	}
+	if gitCfg.Monorepo == "" {
+		PublishJavascript(proto, gitCfg, cloneDir, dryRun, jsCfg)
+	}
+}
+
+// PublishJavascript publishes npm packages of javascript packages to the registry of jsCfg, if there is one.
+// Packages are published once their repos are pushed, so monorepo packages are published after the monorepo is.
+func PublishJavascript(proto config.Proto, gitCfg git.Config, cloneDir string, dryRun bool, jsCfg config.Javascript) {
+	if jsCfg.Registry == "" {
+		return
+	}
+
+	if !jsCfg.PerPackage {
+		repoName, repoPkgsDir := "proto-all-js", ""
+		if gitCfg.Monorepo != "" {
+			repoName, repoPkgsDir = gitCfg.Monorepo, "js"
+		}
+		publishNpmPackage(gitCfg.Release(repoName, ""), path.Join(cloneDir, repoName, repoPkgsDir), jsCfg, dryRun)
+		return
+	}
+	for _, pkg := range discoverPackages(proto.OutDir, loadModel(proto), "ts") {
+		repoName, pkgDir := gitCfg.PackageRepo("js", pkg)
+		publishNpmPackage(gitCfg.Release(repoName, ""), path.Join(cloneDir, repoName, pkgDir), jsCfg, dryRun)
+	}
+}
+
+// rewriteJSImports rewrites relative imports of modules in other packages into imports of their npm packages
+// (e.g "../foo/foo_pb" into "@org/proto-foo/foo_pb"), imported packages are returned.
+func rewriteJSImports(dir string, pkg string, tsPackages []string, jsCfg config.Javascript) []string {
+	var importedPackages []string
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || (filepath.Ext(filePath) != ".ts" && filepath.Ext(filePath) != ".js") {
+			return err
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
 		}
-		err := util.CopyDirectory(path.Join(protoOutDir, "ts", pkg), path.Join(cloneDir, repoName, pkg))
+		relDir, err := filepath.Rel(dir, filepath.Dir(filePath))
 		if err != nil {
+			return err
+		}
+
+		rewritten := jsImportRegexp.ReplaceAllStringFunc(string(data), func(statement string) string {
+			specifier := jsImportRegexp.FindStringSubmatch(statement)[1]
+			if !strings.HasPrefix(specifier, ".") {
+				return statement
+			}
+			// Path of imported module within ts output dir (e.g foo/foo_pb)
+			imported := path.Join(pkg, filepath.ToSlash(relDir), specifier)
+			importedPkg := strings.SplitN(imported, "/", 2)[0]
+			if importedPkg == pkg || !containsString(tsPackages, importedPkg) {
+				return statement
+			}
+			if !containsString(importedPackages, importedPkg) {
+				importedPackages = append(importedPackages, importedPkg)
+			}
+			packageSpecifier := npmPackageName(jsCfg.Scope, "proto-"+importedPkg) + "/" + strings.TrimPrefix(imported, importedPkg+"/")
+			return strings.Replace(statement, specifier, packageSpecifier, 1)
+		})
+
+		return ioutil.WriteFile(filePath, []byte(rewritten), info.Mode())
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return importedPackages
+}
+
+// npmDependencyVersion returns version of a family package dependency.
+// Packages are referenced by git ref, or by version when they are published to npm registry.
+// Monorepo packages can't be installed from git, so they are referenced by version as well.
+func npmDependencyVersion(gitCfg git.Config, jsCfg config.Javascript, pkg string) string {
+	repoName, _ := gitCfg.PackageRepo("js", pkg)
+	if jsCfg.Registry != "" || gitCfg.Monorepo != "" {
+		return releaseVersion(gitCfg, "js", pkg)
+	}
+
+	_, refName := gitCfg.Release(repoName, "").ParseRef()
+	return fmt.Sprintf("git+%s#%s", gitCfg.PackageURL(repoName), refName)
+}
+
+// npmPackageName returns name of an npm package, with a scope if there is one
+func npmPackageName(scope string, name string) string {
+	if scope == "" {
+		return name
+	}
+
+	return "@" + strings.TrimPrefix(scope, "@") + "/" + name
+}
+
+// addPackageExports exports modules of a proto package dir as <pkg>/<module> subpaths,
+// modules in the root of an npm package are exported when pkg is empty.
+// Typescript sources are exported as is, unless they are compiled into dist by compiler.
+func addPackageExports(packageJSON *PackageJSON, pkg string, compiler string) {
+	if packageJSON.Exports == nil {
+		packageJSON.Exports = make(map[string]ExportConditions)
+		packageJSON.TypesVersions = map[string]map[string][]string{"*": {}}
+	}
+
+	packageJSON.Files = npmPackageFiles(compiler)
+	subpath := path.Join(pkg, "*")
+	types := "./" + subpath + ".ts"
+	if compiler == "" {
+		packageJSON.Exports["./"+subpath] = ExportConditions{Types: types, Default: types}
+	} else {
+		// Esbuild doesn't emit declarations, typescript sources provide types then
+		if compiler == "tsc" {
+			types = "./dist/types/" + subpath + ".d.ts"
+		}
+		packageJSON.Exports["./"+subpath] = ExportConditions{
+			Types:   types,
+			Import:  "./dist/esm/" + subpath + ".js",
+			Require: "./dist/cjs/" + subpath + ".js",
+		}
+	}
+	packageJSON.TypesVersions["*"][subpath] = []string{strings.TrimPrefix(types, "./")}
+}
+
+// npmPackageFiles returns files field of package.json, which selects files packed into npm package.
+// Compiled packages consist of dist, typescript sources are packed only when they provide types (esbuild).
+func npmPackageFiles(compiler string) []string {
+	switch compiler {
+	case "":
+		return []string{"**/*.ts", "**/*.js"}
+	case "tsc":
+		return []string{"dist"}
+	}
+
+	return []string{"dist", "**/*.ts"}
+}
+
+// addIndexExport exports index module of a proto package dir as <pkg> subpath, index in the root of
+// an npm package is its main module when pkg is empty. Index is given relative to the root of the npm package.
+func addIndexExport(packageJSON *PackageJSON, pkg string, indexFile string, compiler string) {
+	conditions := ExportConditions{Default: "./" + indexFile}
+	if path.Ext(indexFile) == ".ts" {
+		conditions.Types = "./" + indexFile
+	}
+	if compiler != "" && path.Ext(indexFile) == ".ts" {
+		module := strings.TrimSuffix(indexFile, ".ts")
+		if compiler == "tsc" {
+			conditions.Types = "./dist/types/" + module + ".d.ts"
+		}
+		conditions = ExportConditions{
+			Types:   conditions.Types,
+			Import:  "./dist/esm/" + module + ".js",
+			Require: "./dist/cjs/" + module + ".js",
+		}
+	}
+
+	if pkg == "" {
+		packageJSON.Exports["."] = conditions
+		packageJSON.Main = conditions.Default
+		if conditions.Require != "" {
+			packageJSON.Main = conditions.Require
+		}
+		packageJSON.Types = conditions.Types
+		return
+	}
+	packageJSON.Exports["./"+pkg] = conditions
+	if conditions.Types != "" {
+		packageJSON.TypesVersions["*"][pkg] = []string{strings.TrimPrefix(conditions.Types, "./")}
+	}
+}
+
+// addJSDependencies adds runtime dependencies imported by modules of a package dir
+func addJSDependencies(dependencies map[string]string, dir string, pkg string) {
+	var unknownModules []string
+	for _, match := range jsImportRegexp.FindAllStringSubmatch(readSources(dir, ".ts", ".js"), -1) {
+		specifier := match[1]
+		if strings.HasPrefix(specifier, ".") {
+			continue
+		}
+
+		// Package name of a module specifier, e.g protobufjs of protobufjs/minimal, @grpc/grpc-js of @grpc/grpc-js/build
+		parts := strings.Split(specifier, "/")
+		name := parts[0]
+		if strings.HasPrefix(name, "@") && len(parts) > 1 {
+			name += "/" + parts[1]
+		}
+
+		var isKnown bool
+		for _, known := range knownJSPackages {
+			if known.Path == name {
+				dependencies[name] = known.Version
+				isKnown = true
+			}
+		}
+		if !isKnown && !containsString(unknownModules, specifier) {
+			unknownModules = append(unknownModules, specifier)
+			fmt.Printf("warning: package %s imports unknown module %s, it won't be added to dependencies\n", pkg, specifier)
+		}
+	}
+}
+
+// writePackageJSON writes generated package.json, which replaces package.json of the repo even when it's protected.
+// Hand-maintained fields (e.g license, repository) are kept in package.override.json next to it, which is merged into
+// the generated fields: objects are merged recursively, any other value replaces the generated one.
+func writePackageJSON(filename string, packageJSON PackageJSON) {
+	data, err := json.MarshalIndent(packageJSON, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+
+	overrideFile := path.Join(path.Dir(filename), packageJSONOverrideFile)
+	if overrideData, err := ioutil.ReadFile(overrideFile); err == nil {
+		var generated, override map[string]interface{}
+		if err := json.Unmarshal(data, &generated); err != nil {
 			panic(err)
 		}
+		if err := json.Unmarshal(overrideData, &override); err != nil {
+			panic(fmt.Errorf("invalid %s: %s", overrideFile, err))
+		}
+		mergeJSON(generated, override)
+		if data, err = json.MarshalIndent(generated, "", "  "); err != nil {
+			panic(err)
+		}
+	} else if !os.IsNotExist(err) {
+		panic(err)
+	}
 
+	if err := ioutil.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		panic(err)
 	}
+}
 
-	// Add to GIT
-	AddCommitTagPush(gitCfg, []string{repoName}, dryRun)
+func mergeJSON(dst map[string]interface{}, src map[string]interface{}) {
+	for key, value := range src {
+		srcObject, isSrcObject := value.(map[string]interface{})
+		dstObject, isDstObject := dst[key].(map[string]interface{})
+		if isSrcObject && isDstObject {
+			mergeJSON(dstObject, srcObject)
+			continue
+		}
+		dst[key] = value
+	}
 }
@@ -0,0 +1,63 @@
+package target
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestWritePackageJSON(t *testing.T) {
+	packageJSON := PackageJSON{
+		Name:         "@org/proto-foo",
+		Version:      "1.0.0",
+		Description:  "Protobuf package foo generated by protodist",
+		Main:         "./index.ts",
+		Dependencies: map[string]string{"long": "^4.0.0"},
+	}
+
+	dir := t.TempDir()
+	filename := path.Join(dir, "package.json")
+	writePackageJSON(filename, packageJSON)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "name": "@org/proto-foo",
+  "version": "1.0.0",
+  "description": "Protobuf package foo generated by protodist",
+  "main": "./index.ts",
+  "dependencies": {
+    "long": "^4.0.0"
+  }
+}
+`
+	if string(data) != want {
+		t.Errorf("package.json =\n%s\nwant\n%s", data, want)
+	}
+
+	override := `{"description": "Foo protos", "license": "MIT", "dependencies": {"google-protobuf": "^3.14.0"}}`
+	if err := ioutil.WriteFile(path.Join(dir, packageJSONOverrideFile), []byte(override), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writePackageJSON(filename, packageJSON)
+	data, err = ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `{
+  "dependencies": {
+    "google-protobuf": "^3.14.0",
+    "long": "^4.0.0"
+  },
+  "description": "Foo protos",
+  "license": "MIT",
+  "main": "./index.ts",
+  "name": "@org/proto-foo",
+  "version": "1.0.0"
+}
+`
+	if string(data) != want {
+		t.Errorf("package.json with override =\n%s\nwant\n%s", data, want)
+	}
+}
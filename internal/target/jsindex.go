@@ -0,0 +1,211 @@
+package target
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Names exported by declarations (e.g export class Foo) and export lists (e.g export { Foo, Bar as Baz }) of ES modules
+var jsExportRegexp = regexp.MustCompile(`(?m)^export\s+(?:declare\s+)?(?:abstract\s+)?(?:async\s+)?(?:const\s+enum|const|let|var|function\*?|class|enum|interface|type|namespace)\s+([A-Za-z_$][\w$]*)`)
+var jsExportListRegexp = regexp.MustCompile(`(?m)^export\s+(?:type\s+)?\{([^}]*)\}`)
+
+// Export statements, which CommonJS modules don't have
+var jsESModuleRegexp = regexp.MustCompile(`(?m)^export\s`)
+
+// Characters which are not allowed in javascript identifiers
+var jsIdentifierRegexp = regexp.MustCompile(`[^A-Za-z0-9_$]`)
+
+// writePackageIndex writes index.ts of a package dir (index.js when the package has no typescript modules), which
+// re-exports all modules of the package. Modules exporting a name which is exported by another module as well are
+// re-exported as namespaces, since flat re-exports would be ambiguous. Namespaces are identifiers of module paths,
+// a numeric suffix is added to namespaces which would collide. Filename of the index is returned, along with
+// whether it is a CommonJS module, filename is empty when the package has no modules.
+func writePackageIndex(dir string, pkg string) (string, bool) {
+	ext := ".ts"
+	modules := jsModules(dir, ext)
+	if len(modules) == 0 {
+		ext = ".js"
+		modules = jsModules(dir, ext)
+	}
+	if len(modules) == 0 {
+		return "", false
+	}
+
+	// Modules generated by protoc-gen-js are CommonJS, their exports can't be listed statically, so they are always namespaced
+	sources := make(map[string]string)
+	isCommonJS := true
+	for _, module := range modules {
+		data, err := ioutil.ReadFile(path.Join(dir, module+ext))
+		if err != nil {
+			panic(err)
+		}
+		sources[module] = string(data)
+		if jsESModuleRegexp.MatchString(sources[module]) {
+			isCommonJS = false
+		}
+	}
+
+	namespaced := make(map[string]bool)
+	exportedBy := make(map[string]string)
+	for _, module := range modules {
+		for _, name := range jsExportedNames(sources[module]) {
+			if other, ok := exportedBy[name]; ok && other != module {
+				fmt.Printf("warning: %s is exported by both %s and %s of package %s, index exports them as namespaces\n", name, other, module, pkg)
+				namespaced[other] = true
+				namespaced[module] = true
+				continue
+			}
+			exportedBy[name] = module
+		}
+	}
+
+	namespaces := make(jsNamespaces)
+	namespace := func(module string) string {
+		identifier, other := namespaces.add(module)
+		if other != "" {
+			fmt.Printf("warning: modules %s and %s of package %s are both exported as %s by index, %s is exported as %s\n", other, module, pkg, jsIdentifier(module), module, identifier)
+		}
+		return identifier
+	}
+
+	buffer := bytes.NewBufferString("// Code generated by protodist. DO NOT EDIT.\n\n")
+	for _, module := range modules {
+		switch {
+		case isCommonJS:
+			fmt.Fprintf(buffer, "exports.%s = require(\"./%s\");\n", namespace(module), module)
+		case namespaced[module]:
+			fmt.Fprintf(buffer, "export * as %s from \"./%s\";\n", namespace(module), module)
+		default:
+			fmt.Fprintf(buffer, "export * from \"./%s\";\n", module)
+		}
+	}
+
+	filename := path.Join(dir, "index"+ext)
+	if err := ioutil.WriteFile(filename, buffer.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+
+	return filename, isCommonJS
+}
+
+// writeRootIndex writes index of an npm package with multiple proto packages, which re-exports index of each package
+// as a namespace named after the package, so that names of different packages never collide. Namespaces of packages
+// which would collide get a numeric suffix. Package indexes are given by package name, filename is returned.
+func writeRootIndex(dir string, packageIndexes map[string]string, commonJS bool) string {
+	var pkgs []string
+	ext := ".js"
+	for pkg, indexFile := range packageIndexes {
+		pkgs = append(pkgs, pkg)
+		if path.Ext(indexFile) == ".ts" {
+			ext = ".ts"
+		}
+	}
+	sort.Strings(pkgs)
+
+	buffer := bytes.NewBufferString("// Code generated by protodist. DO NOT EDIT.\n\n")
+	namespaces := make(jsNamespaces)
+	for _, pkg := range pkgs {
+		namespace, other := namespaces.add(pkg)
+		if other != "" {
+			fmt.Printf("warning: packages %s and %s are both exported as %s by index, %s is exported as %s\n", other, pkg, jsIdentifier(pkg), pkg, namespace)
+		}
+
+		if commonJS && ext == ".js" {
+			fmt.Fprintf(buffer, "exports.%s = require(\"./%s\");\n", namespace, pkg)
+		} else {
+			fmt.Fprintf(buffer, "export * as %s from \"./%s\";\n", namespace, pkg)
+		}
+	}
+
+	filename := path.Join(dir, "index"+ext)
+	if err := ioutil.WriteFile(filename, buffer.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+
+	return filename
+}
+
+// jsNamespaces are identifiers of exported namespaces, mapped to module paths or package names they export
+type jsNamespaces map[string]string
+
+// add returns a unique identifier of a module path or package name. Identifiers of different names may collide
+// (e.g foo_bar_pb of foo/bar_pb and foo_bar_pb), colliding ones get a numeric suffix and the name they collided
+// with is returned as well.
+func (n jsNamespaces) add(name string) (string, string) {
+	identifier := jsIdentifier(name)
+	other, collides := n[identifier]
+	if collides {
+		unique := identifier
+		for i := 2; n[unique] != ""; i++ {
+			unique = fmt.Sprintf("%s_%d", identifier, i)
+		}
+		identifier = unique
+	}
+	n[identifier] = name
+
+	return identifier, other
+}
+
+// jsModules returns modules of a dir with an extension, as paths relative to the dir without the extension.
+// Declarations and index of the dir are left out.
+func jsModules(dir string, ext string) []string {
+	var modules []string
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() && (info.Name() == "node_modules" || info.Name() == "dist" || info.Name() == ".git") {
+			return filepath.SkipDir
+		}
+		if err != nil || info.IsDir() || filepath.Ext(filePath) != ext || strings.HasSuffix(filePath, ".d.ts") {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		if relPath != "index"+ext {
+			modules = append(modules, strings.TrimSuffix(filepath.ToSlash(relPath), ext))
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	sort.Strings(modules)
+
+	return modules
+}
+
+// jsExportedNames returns names exported by an ES module
+func jsExportedNames(source string) []string {
+	var names []string
+	for _, match := range jsExportRegexp.FindAllStringSubmatch(source, -1) {
+		names = append(names, match[1])
+	}
+	for _, match := range jsExportListRegexp.FindAllStringSubmatch(source, -1) {
+		for _, specifier := range strings.Split(match[1], ",") {
+			// Exported name of a specifier is the last word (e.g Baz of Bar as Baz)
+			fields := strings.Fields(specifier)
+			if len(fields) > 0 && fields[len(fields)-1] != "default" {
+				names = append(names, fields[len(fields)-1])
+			}
+		}
+	}
+
+	return names
+}
+
+// jsIdentifier returns a javascript identifier of a module path or package name (e.g foo_bar_pb of foo/bar_pb)
+func jsIdentifier(name string) string {
+	identifier := jsIdentifierRegexp.ReplaceAllString(name, "_")
+	if identifier == "" || (identifier[0] >= '0' && identifier[0] <= '9') {
+		identifier = "_" + identifier
+	}
+
+	return identifier
+}
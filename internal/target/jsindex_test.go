@@ -0,0 +1,135 @@
+package target
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func writeJSModules(t *testing.T, dir string, modules map[string]string) {
+	for name, content := range modules {
+		if err := os.MkdirAll(path.Dir(path.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWritePackageIndex(t *testing.T) {
+	tests := []struct {
+		name         string
+		modules      map[string]string
+		wantFile     string
+		wantCommonJS bool
+		want         string
+	}{
+		{
+			name: "flat",
+			modules: map[string]string{
+				"foo_pb.ts":     "export interface Foo {}\n",
+				"bar/bar_pb.ts": "export const Bar = 1;\nexport { Bar as Baz };\n",
+			},
+			wantFile: "index.ts",
+			want:     "// Code generated by protodist. DO NOT EDIT.\n\nexport * from \"./bar/bar_pb\";\nexport * from \"./foo_pb\";\n",
+		},
+		{
+			name: "colliding namespaces",
+			modules: map[string]string{
+				"foo/bar_pb.ts": "export interface Msg {}\n",
+				"foo_bar_pb.ts": "export interface Msg {}\n",
+				"baz_pb.ts":     "export interface Baz {}\n",
+			},
+			wantFile: "index.ts",
+			want: "// Code generated by protodist. DO NOT EDIT.\n\n" +
+				"export * from \"./baz_pb\";\n" +
+				"export * as foo_bar_pb from \"./foo/bar_pb\";\n" +
+				"export * as foo_bar_pb_2 from \"./foo_bar_pb\";\n",
+		},
+		{
+			name: "colliding CommonJS modules",
+			modules: map[string]string{
+				"foo/bar_pb.js": "goog.exportSymbol('proto.foo.Bar', null, global);\n",
+				"foo_bar_pb.js": "goog.exportSymbol('proto.foo_bar.Bar', null, global);\n",
+			},
+			wantFile:     "index.js",
+			wantCommonJS: true,
+			want: "// Code generated by protodist. DO NOT EDIT.\n\n" +
+				"exports.foo_bar_pb = require(\"./foo/bar_pb\");\n" +
+				"exports.foo_bar_pb_2 = require(\"./foo_bar_pb\");\n",
+		},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		writeJSModules(t, dir, test.modules)
+
+		filename, isCommonJS := writePackageIndex(dir, "foo")
+		if filename != path.Join(dir, test.wantFile) || isCommonJS != test.wantCommonJS {
+			t.Errorf("%s: writePackageIndex() = %s, %t, want %s, %t", test.name, filename, isCommonJS, test.wantFile, test.wantCommonJS)
+			continue
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != test.want {
+			t.Errorf("%s: index =\n%s\nwant\n%s", test.name, data, test.want)
+		}
+	}
+}
+
+func TestWriteRootIndex(t *testing.T) {
+	tests := []struct {
+		name           string
+		packageIndexes map[string]string
+		commonJS       bool
+		wantFile       string
+		want           string
+	}{
+		{
+			name:           "ES modules",
+			packageIndexes: map[string]string{"foo": "foo/index.ts", "bar": "bar/index.ts"},
+			wantFile:       "index.ts",
+			want: "// Code generated by protodist. DO NOT EDIT.\n\n" +
+				"export * as bar from \"./bar\";\n" +
+				"export * as foo from \"./foo\";\n",
+		},
+		{
+			name:           "colliding namespaces",
+			packageIndexes: map[string]string{"foo-bar": "foo-bar/index.ts", "foo_bar": "foo_bar/index.ts", "foo.bar": "foo.bar/index.ts"},
+			wantFile:       "index.ts",
+			want: "// Code generated by protodist. DO NOT EDIT.\n\n" +
+				"export * as foo_bar from \"./foo-bar\";\n" +
+				"export * as foo_bar_2 from \"./foo.bar\";\n" +
+				"export * as foo_bar_3 from \"./foo_bar\";\n",
+		},
+		{
+			name:           "colliding CommonJS namespaces",
+			packageIndexes: map[string]string{"foo-bar": "foo-bar/index.js", "foo_bar": "foo_bar/index.js"},
+			commonJS:       true,
+			wantFile:       "index.js",
+			want: "// Code generated by protodist. DO NOT EDIT.\n\n" +
+				"exports.foo_bar = require(\"./foo-bar\");\n" +
+				"exports.foo_bar_2 = require(\"./foo_bar\");\n",
+		},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		filename := writeRootIndex(dir, test.packageIndexes, test.commonJS)
+		if filename != path.Join(dir, test.wantFile) {
+			t.Errorf("%s: writeRootIndex() = %s, want %s", test.name, filename, test.wantFile)
+			continue
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != test.want {
+			t.Errorf("%s: index =\n%s\nwant\n%s", test.name, data, test.want)
+		}
+	}
+}
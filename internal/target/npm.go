@@ -0,0 +1,235 @@
+package target
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/4nte/protodist/config"
+	"github.com/4nte/protodist/git"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Files which npm never packs, and files which it always packs regardless of files field and ignore files
+var npmIgnoredFiles = []string{packageJSONOverrideFile, ".git", "node_modules", ".npmrc", "package-lock.json", "npm-debug.log", ".DS_Store", "*.orig", ".*.swp"}
+var npmIncludedFiles = []string{"package.json", "README*", "LICENSE*", "LICENCE*", "CHANGELOG*"}
+
+// publishNpmPackage packs a package dir into a tarball, the same way npm pack does, and publishes it to the npm registry.
+// Manifest of the published version is package.json of the dir.
+// Only tagged releases are published, branch versions (0.0.0) would collide in the registry.
+func publishNpmPackage(cfg git.Config, dir string, jsCfg config.Javascript, dryRun bool) {
+	manifest, err := ioutil.ReadFile(path.Join(dir, "package.json"))
+	if err != nil {
+		panic(err)
+	}
+	var packageJSON PackageJSON
+	if err := json.Unmarshal(manifest, &packageJSON); err != nil {
+		panic(fmt.Errorf("failed to parse package.json of %s: %s", dir, err))
+	}
+
+	if refType, _ := cfg.ParseRef(); refType != git.TagRef {
+		fmt.Printf("skipping npm publish of %s, only tagged releases are published\n", packageJSON.Name)
+		return
+	}
+
+	tarball, err := tarGz(dir, "package", npmPackFilter(dir, packageJSON.Files))
+	if err != nil {
+		panic(fmt.Errorf("failed to pack npm package %s: %s", packageJSON.Name, err))
+	}
+	if dryRun {
+		fmt.Printf("dry run, %s@%s (%d bytes) won't be published to %s\n", packageJSON.Name, packageJSON.Version, len(tarball), jsCfg.Registry)
+		return
+	}
+
+	fmt.Printf("publishing %s@%s to %s\n", packageJSON.Name, packageJSON.Version, jsCfg.Registry)
+	git.RegisterSecret(jsCfg.RegistryToken)
+	if err := putNpmPackage(jsCfg.Registry, jsCfg.RegistryToken, manifest, tarball); err != nil {
+		panic(err)
+	}
+}
+
+// npmPackFilter returns a filter of files packed by npm pack. Files are selected by files field of package.json,
+// or they are all packed except those ignored by .npmignore (.gitignore when there is no .npmignore) of the dir.
+// Patterns are matched the way gitignore matches them.
+func npmPackFilter(dir string, files []string) func(relPath string) bool {
+	var ignorePatterns []string
+	if len(files) == 0 {
+		for _, ignoreFile := range []string{".npmignore", ".gitignore"} {
+			data, err := ioutil.ReadFile(path.Join(dir, ignoreFile))
+			if err != nil {
+				continue
+			}
+			for _, line := range strings.Split(string(data), "\n") {
+				if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+					ignorePatterns = append(ignorePatterns, line)
+				}
+			}
+			break
+		}
+	}
+
+	return func(relPath string) bool {
+		for _, pattern := range npmIgnoredFiles {
+			if npmPatternMatch(pattern, relPath) {
+				return false
+			}
+		}
+		for _, pattern := range npmIncludedFiles {
+			if !strings.Contains(relPath, "/") && npmPatternMatch(pattern, relPath) {
+				return true
+			}
+		}
+
+		if len(files) > 0 {
+			included := false
+			for _, pattern := range files {
+				if strings.HasPrefix(pattern, "!") {
+					included = included && !npmPatternMatch("/"+strings.TrimPrefix(strings.TrimPrefix(pattern, "!"), "./"), relPath)
+				} else if npmPatternMatch("/"+strings.TrimPrefix(pattern, "./"), relPath) {
+					included = true
+				}
+			}
+			return included
+		}
+
+		// Later patterns override earlier ones, negated patterns include ignored files again
+		included := true
+		for _, pattern := range ignorePatterns {
+			if strings.HasPrefix(pattern, "!") {
+				if npmPatternMatch(strings.TrimPrefix(pattern, "!"), relPath) {
+					included = true
+				}
+			} else if npmPatternMatch(pattern, relPath) {
+				included = false
+			}
+		}
+		return included
+	}
+}
+
+// npmPatternMatch reports whether a gitignore pattern matches a file, or a directory the file resides in.
+// Patterns with a slash (other than a trailing one) are anchored to the root, others match a name at any depth.
+// Trailing slash matches only directories, ** matches any number of directories.
+func npmPatternMatch(pattern string, relPath string) bool {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	anchored := strings.Contains(pattern, "/")
+	patternSegments := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	segments := strings.Split(relPath, "/")
+
+	for end := 1; end <= len(segments); end++ {
+		if dirOnly && end == len(segments) {
+			break
+		}
+		if anchored {
+			if matchSegments(patternSegments, segments[:end]) {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(patternSegments[0], segments[end-1]); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchSegments matches path segments with pattern segments, ** segment matches any number of segments
+func matchSegments(patternSegments []string, segments []string) bool {
+	if len(patternSegments) == 0 {
+		return len(segments) == 0
+	}
+	if patternSegments[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(patternSegments[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(patternSegments[0], segments[0]); !matched {
+		return false
+	}
+
+	return matchSegments(patternSegments[1:], segments[1:])
+}
+
+// putNpmPackage publishes a package version through the registry API used by npm publish.
+// Manifest of the version is made of package.json data.
+func putNpmPackage(registry string, token string, packageJSONData []byte, tarball []byte) error {
+	var packageJSON PackageJSON
+	if err := json.Unmarshal(packageJSONData, &packageJSON); err != nil {
+		return err
+	}
+	registry = strings.TrimSuffix(registry, "/")
+	// Tarball of @org/foo is named foo-<version>.tgz
+	tarballName := fmt.Sprintf("%s-%s.tgz", packageJSON.Name[strings.LastIndex(packageJSON.Name, "/")+1:], packageJSON.Version)
+
+	// Version manifest is package.json, along with dist info of the tarball
+	var manifest map[string]interface{}
+	if err := json.Unmarshal(packageJSONData, &manifest); err != nil {
+		return err
+	}
+	shasum := sha1.Sum(tarball)
+	integrity := sha512.Sum512(tarball)
+	manifest["_id"] = packageJSON.Name + "@" + packageJSON.Version
+	manifest["dist"] = map[string]string{
+		"shasum":    hex.EncodeToString(shasum[:]),
+		"integrity": "sha512-" + base64.StdEncoding.EncodeToString(integrity[:]),
+		"tarball":   fmt.Sprintf("%s/%s/-/%s", registry, packageJSON.Name, tarballName),
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"_id":         packageJSON.Name,
+		"name":        packageJSON.Name,
+		"description": packageJSON.Description,
+		"dist-tags":   map[string]string{"latest": packageJSON.Version},
+		"versions":    map[string]interface{}{packageJSON.Version: manifest},
+		"_attachments": map[string]interface{}{
+			tarballName: map[string]interface{}{
+				"content_type": "application/octet-stream",
+				"data":         base64.StdEncoding.EncodeToString(tarball),
+				"length":       len(tarball),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Scoped package names are escaped (e.g @org%2ffoo)
+	endpoint := registry + "/" + strings.Replace(packageJSON.Name, "/", "%2f", 1)
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := (&http.Client{Timeout: 60 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to publish %s: %s", packageJSON.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to publish %s@%s: %s: %s", packageJSON.Name, packageJSON.Version, resp.Status, git.Scrub(string(respBody)))
+	}
+
+	return nil
+}
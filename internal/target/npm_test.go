@@ -0,0 +1,164 @@
+package target
+
+import (
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestPutNpmPackage(t *testing.T) {
+	type registryRequest struct {
+		Method        string
+		URI           string
+		Authorization string
+		Body          map[string]interface{}
+	}
+	var requests []registryRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := registryRequest{Method: r.Method, URI: r.RequestURI, Authorization: r.Header.Get("Authorization")}
+		if err := json.NewDecoder(r.Body).Decode(&request.Body); err != nil {
+			t.Errorf("invalid JSON body: %s", err)
+		}
+		requests = append(requests, request)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	packageJSON := []byte(`{"name": "@org/proto-foo", "version": "1.2.0", "description": "Protobuf package foo", "main": "./index.ts", "custom": "kept"}`)
+	tarball := []byte("tarball")
+	if err := putNpmPackage(server.URL+"/", "token", packageJSON, tarball); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	request := requests[0]
+	if request.Method != http.MethodPut || request.URI != "/@org%2fproto-foo" {
+		t.Errorf("request = %s %s, want PUT /@org%%2fproto-foo", request.Method, request.URI)
+	}
+	if request.Authorization != "Bearer token" {
+		t.Errorf("Authorization = %s, want Bearer token", request.Authorization)
+	}
+
+	body := request.Body
+	if body["_id"] != "@org/proto-foo" || body["name"] != "@org/proto-foo" || body["description"] != "Protobuf package foo" {
+		t.Errorf("document = %v", body)
+	}
+	if distTags, _ := body["dist-tags"].(map[string]interface{}); distTags["latest"] != "1.2.0" {
+		t.Errorf("dist-tags = %v, want latest 1.2.0", body["dist-tags"])
+	}
+
+	version, _ := body["versions"].(map[string]interface{})["1.2.0"].(map[string]interface{})
+	if version["_id"] != "@org/proto-foo@1.2.0" || version["main"] != "./index.ts" || version["custom"] != "kept" {
+		t.Errorf("version manifest = %v", version)
+	}
+	shasum := sha1.Sum(tarball)
+	integrity := sha512.Sum512(tarball)
+	wantDist := map[string]interface{}{
+		"shasum":    hex.EncodeToString(shasum[:]),
+		"integrity": "sha512-" + base64.StdEncoding.EncodeToString(integrity[:]),
+		"tarball":   server.URL + "/@org/proto-foo/-/proto-foo-1.2.0.tgz",
+	}
+	if dist, _ := version["dist"].(map[string]interface{}); len(dist) != len(wantDist) || dist["shasum"] != wantDist["shasum"] || dist["integrity"] != wantDist["integrity"] || dist["tarball"] != wantDist["tarball"] {
+		t.Errorf("dist = %v, want %v", version["dist"], wantDist)
+	}
+
+	attachments, _ := body["_attachments"].(map[string]interface{})
+	attachment, ok := attachments["proto-foo-1.2.0.tgz"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("_attachments = %v, want proto-foo-1.2.0.tgz", attachments)
+	}
+	data, err := base64.StdEncoding.DecodeString(attachment["data"].(string))
+	if err != nil || string(data) != string(tarball) {
+		t.Errorf("attachment data = %s, want %s", data, tarball)
+	}
+	if attachment["length"] != float64(len(tarball)) {
+		t.Errorf("attachment length = %v, want %d", attachment["length"], len(tarball))
+	}
+}
+
+func TestPutNpmPackageError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	if err := putNpmPackage(server.URL, "", []byte(`{"name": "proto-foo", "version": "1.0.0"}`), nil); err == nil {
+		t.Error("expected an error for status 403")
+	}
+}
+
+func TestNpmPackFilter(t *testing.T) {
+	paths := []string{
+		"package.json",
+		"README.md",
+		"tsconfig.json",
+		".npmignore",
+		".github/workflows/ci.yml",
+		"node_modules/long/index.js",
+		"index.ts",
+		"foo/foo_pb.ts",
+		"foo/test/foo_test.ts",
+		"dist/cjs/foo/foo_pb.js",
+		"dist/types/foo/foo_pb.d.ts",
+	}
+
+	tests := []struct {
+		name      string
+		files     []string
+		npmignore string
+		want      []string
+	}{
+		{
+			name:  "files",
+			files: []string{"dist"},
+			want:  []string{"package.json", "README.md", "dist/cjs/foo/foo_pb.js", "dist/types/foo/foo_pb.d.ts"},
+		},
+		{
+			name:  "files globs",
+			files: []string{"**/*.ts", "!foo/test"},
+			want:  []string{"package.json", "README.md", "index.ts", "foo/foo_pb.ts", "dist/types/foo/foo_pb.d.ts"},
+		},
+		{
+			name:      "npmignore",
+			npmignore: "# sources\n*.ts\n!index.ts\n.github/\n/tsconfig.json\n.npmignore\n",
+			want:      []string{"package.json", "README.md", "index.ts", "dist/cjs/foo/foo_pb.js"},
+		},
+		{
+			name: "everything",
+			want: []string{"package.json", "README.md", "tsconfig.json", ".github/workflows/ci.yml", "index.ts", "foo/foo_pb.ts", "foo/test/foo_test.ts", "dist/cjs/foo/foo_pb.js", "dist/types/foo/foo_pb.d.ts"},
+		},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		if test.npmignore != "" {
+			if err := ioutil.WriteFile(path.Join(dir, ".npmignore"), []byte(test.npmignore), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		include := npmPackFilter(dir, test.files)
+		var got []string
+		for _, relPath := range paths {
+			if relPath == ".npmignore" && test.npmignore == "" {
+				continue
+			}
+			if include(relPath) {
+				got = append(got, relPath)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: packed %v, want %v", test.name, got, test.want)
+		}
+	}
+}
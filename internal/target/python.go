@@ -0,0 +1,230 @@
+package target
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/4nte/protodist/config"
+	"github.com/4nte/protodist/git"
+	"github.com/4nte/protodist/util"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const PyProjectTemplate = `[build-system]
+requires = ["setuptools>=61"]
+build-backend = "setuptools.build_meta"
+
+[project]
+name = "{{ .Name }}"
+version = "{{ .Version }}"
+requires-python = ">=3.7"
+dependencies = [
+{{- range .Dependencies }}
+    "{{ . }}",
+{{- end }}
+]
+
+[tool.setuptools.packages.find]
+include = ["{{ .Package }}", "{{ .Package }}.*"]
+
+[tool.setuptools.package-data]
+"*" = ["*.pyi", "py.typed"]
+`
+
+// Top level module of python import statements (e.g "from foo.bar import baz_pb2")
+var pythonImportRegexp = regexp.MustCompile(`^\s*(?:from|import)\s+([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Runs of separators, which are normalized to "-" in python project names (PEP 503)
+var pythonSeparatorRegexp = regexp.MustCompile(`[-_.]+`)
+
+// Python distributes packages as python projects, with pyproject.toml in the root of a repo dir and
+// generated modules in a python package with the name of a proto package.
+func Python(proto config.Proto, gitCfg git.Config, cloneBranch string, cloneDir string, dryRun bool, deployTarget string, deployDir string, pythonCfg config.Python) {
+	model := loadModel(proto)
+	pyPackages := discoverPackages(proto.OutDir, model, "python")
+
+	// Clone python proto repos, monorepo is already cloned
+	if gitCfg.Monorepo == "" {
+		for _, pkg := range pyPackages {
+			repoName, _ := gitCfg.PackageRepo("python", pkg)
+			git.CloneOrCreate(gitCfg, repoName, cloneBranch, dryRun)
+		}
+	}
+
+	for _, pkg := range pyPackages {
+		repoName, pkgDir := gitCfg.PackageRepo("python", pkg)
+		repoDir := path.Join(cloneDir, repoName, pkgDir)
+		generatedPkgDir := path.Join(proto.OutDir, "python", pkg)
+
+		// Replace python package with generated one, so that modules of deleted protos don't linger
+		modulesDir := path.Join(repoDir, pkg)
+		if err := os.RemoveAll(modulesDir); err != nil {
+			panic(err)
+		}
+		if err := util.CreateIfNotExists(modulesDir, 0755); err != nil {
+			panic(err)
+		}
+		if err := util.CopyDirectory(generatedPkgDir, modulesDir); err != nil {
+			panic(err)
+		}
+		if err := addInitFiles(modulesDir); err != nil {
+			panic(err)
+		}
+
+		dependencies := []string{"protobuf" + pythonCfg.Protobuf}
+		if hasFileWithSuffix(modulesDir, "_pb2_grpc.py") {
+			dependencies = append(dependencies, "grpcio"+pythonCfg.Grpcio)
+		}
+		deps := familyDeps(model, "python", pkg, pyPackages, func() []string {
+			return pythonImportedPackages(modulesDir, pkg, pyPackages)
+		})
+		for _, dep := range deps {
+			dependencies = append(dependencies, pythonRequirement(gitCfg, pythonCfg, dep))
+		}
+
+		writePyProject(path.Join(repoDir, "pyproject.toml"), pythonProject(pkg), releaseVersion(gitCfg, "python", pkg), pkg, dependencies)
+	}
+
+	// Monorepo is published once all targets are done
+	if gitCfg.Monorepo == "" {
+		for _, pkg := range pyPackages {
+			repoName, _ := gitCfg.PackageRepo("python", pkg)
+			AddCommitTagPublish(gitCfg.Release(repoName, ""), repoName, []string{pkg}, dryRun)
+		}
+	}
+
+	if pythonCfg.IndexDir == "" {
+		return
+	}
+	for _, pkg := range pyPackages {
+		repoName, pkgDir := gitCfg.PackageRepo("python", pkg)
+		if dryRun {
+			fmt.Printf("dry run, distribution of %s won't be built into %s\n", pythonProject(pkg), pythonCfg.IndexDir)
+			continue
+		}
+		buildPythonDist(path.Join(cloneDir, repoName, pkgDir), path.Join(pythonCfg.IndexDir, pythonProject(pkg)))
+	}
+}
+
+// pythonProject returns the normalized name of python project of a proto package (e.g proto-foo-bar for foo_bar)
+func pythonProject(pkg string) string {
+	return "proto-" + pythonSeparatorRegexp.ReplaceAllString(strings.ToLower(pkg), "-")
+}
+
+// pythonRequirement returns requirement specifier of a family package.
+// Packages are required by version when they are installed from a package index, or by git ref otherwise.
+func pythonRequirement(gitCfg git.Config, pythonCfg config.Python, pkg string) string {
+	if pythonCfg.IndexDir != "" {
+		return fmt.Sprintf("%s==%s", pythonProject(pkg), releaseVersion(gitCfg, "python", pkg))
+	}
+
+	repoName, pkgDir := gitCfg.PackageRepo("python", pkg)
+	_, refName := gitCfg.Release(repoName, "").ParseRef()
+	requirement := fmt.Sprintf("%s @ git+%s@%s", pythonProject(pkg), gitCfg.PackageURL(repoName), refName)
+	if pkgDir != "" {
+		requirement += "#subdirectory=" + pkgDir
+	}
+
+	return requirement
+}
+
+// pythonImportedPackages returns proto packages imported by generated modules of a python package
+func pythonImportedPackages(modulesDir string, pkg string, pyPackages []string) []string {
+	var deps []string
+	err := filepath.Walk(modulesDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(filePath) != ".py" {
+			return err
+		}
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			match := pythonImportRegexp.FindStringSubmatch(scanner.Text())
+			if match == nil || match[1] == pkg || !containsString(pyPackages, match[1]) {
+				continue
+			}
+			if !containsString(deps, match[1]) {
+				deps = append(deps, match[1])
+			}
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return deps
+}
+
+// addInitFiles adds empty __init__.py to every directory of a python package which doesn't have one
+func addInitFiles(dir string) error {
+	return filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		initFile := path.Join(filePath, "__init__.py")
+		if util.Exists(initFile) {
+			return nil
+		}
+		return ioutil.WriteFile(initFile, nil, 0644)
+	})
+}
+
+func writePyProject(filename string, name string, version string, pkg string, dependencies []string) {
+	type PyProjectData struct {
+		Name         string
+		Version      string
+		Package      string
+		Dependencies []string
+	}
+
+	tmpl, err := template.New("pyproject").Parse(PyProjectTemplate)
+	if err != nil {
+		panic(err)
+	}
+	buffer := bytes.NewBuffer(nil)
+	data := PyProjectData{Name: name, Version: version, Package: pkg, Dependencies: dependencies}
+	if err := tmpl.Execute(buffer, data); err != nil {
+		panic(err)
+	}
+
+	if err := ioutil.WriteFile(filename, buffer.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+}
+
+// buildPythonDist builds wheel and sdist of a python project into a dir
+func buildPythonDist(projectDir string, outDir string) {
+	fmt.Println("building python dist", projectDir)
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		panic(err)
+	}
+
+	// Build in a copy of the project, so that build artifacts don't end up in the repo
+	buildDir, err := ioutil.TempDir("", "protodist-python-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(buildDir)
+	if err := util.CopyDirectory(projectDir, buildDir); err != nil {
+		panic(err)
+	}
+
+	cmd := exec.Command("python3", "-m", "build", "--sdist", "--wheel", "--outdir", outDir, buildDir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		panic(fmt.Errorf("failed to build python dist: %s: %s", projectDir, err))
+	}
+}
@@ -0,0 +1,374 @@
+package target
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/4nte/protodist/config"
+	"github.com/4nte/protodist/git"
+	"github.com/4nte/protodist/util"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const CargoTomlTemplate = `[package]
+name = "{{ .Name }}"
+version = "{{ .Version }}"
+edition = "2018"
+
+[dependencies]
+{{ range .Dependencies -}}
+{{ . }}
+{{ end -}}
+`
+
+// Paths into family crates, generated by prost with extern_path (e.g ::proto_foo::foo::Bar)
+var rustCrateRefRegexp = regexp.MustCompile(`\b(proto_[A-Za-z0-9_]+)::`)
+
+// Rust distributes packages as cargo crates with prost (and tonic) generated sources in src dir.
+// Generated files are named by proto package (e.g foo.v1.rs), lib.rs nests them into modules of proto package hierarchy.
+// Family crates are referenced by git tag or commit, or by version when crates are packaged into a local registry.
+func Rust(proto config.Proto, gitCfg git.Config, cloneBranch string, cloneDir string, dryRun bool, deployTarget string, deployDir string, rustCfg config.Rust) {
+	model := loadModel(proto)
+	rustPackages := discoverPackages(proto.OutDir, model, "rust")
+	cratePackages := make(map[string]string)
+	for _, pkg := range rustPackages {
+		cratePackages[rustCrate(pkg)] = pkg
+	}
+
+	// Clone rust proto repos, monorepo is already cloned
+	if gitCfg.Monorepo == "" {
+		for _, pkg := range rustPackages {
+			repoName, _ := gitCfg.PackageRepo("rust", pkg)
+			git.CloneOrCreate(gitCfg, repoName, cloneBranch, dryRun)
+		}
+	}
+
+	// Dependencies of each crate, dependency lines of Cargo.toml in local registry are rendered from them
+	crateDeps := make(map[string][]Module)
+
+	depResolver := NewDependencyResolver(func(crate string, requiredPackages []Module) string {
+		fmt.Println("resolving crate", crate)
+		crateDeps[crate] = requiredPackages
+
+		pkg := cratePackages[crate]
+		repoName, crateDir := gitCfg.PackageRepo("rust", pkg)
+		var dependencies []string
+		for _, dep := range requiredPackages {
+			if depPkg, ok := cratePackages[dep.Path]; ok {
+				dependencies = append(dependencies, rustGitDependency(gitCfg, depPkg, dep.Version))
+			} else {
+				dependencies = append(dependencies, fmt.Sprintf(`%s = "%s"`, dep.Path, dep.Version))
+			}
+		}
+		writeCargoToml(path.Join(cloneDir, repoName, crateDir, "Cargo.toml"), crate, releaseVersion(gitCfg, "rust", pkg), dependencies)
+
+		// Monorepo is published once all targets are done, crates are referenced by ref of the monorepo
+		crateCfg := gitCfg.Release(repoName, "")
+		refType, refName := crateCfg.ParseRef()
+		if gitCfg.Monorepo != "" {
+			return refName
+		}
+
+		git.AddAll(repoName)
+		var commit git.CommitInfo
+		if git.HasStagedChanges(repoName) {
+			commit = git.Commit(repoName, "add pb files")
+		} else {
+			commit = git.LastCommit(repoName)
+		}
+		if refType == git.TagRef {
+			git.Tag(repoName, refName)
+		}
+		if !dryRun {
+			git.Publish(crateCfg, repoName, []string{pkg})
+		}
+
+		if refType == git.TagRef {
+			return refName
+		}
+		return commit.Hash
+	})
+
+	for _, pkg := range rustPackages {
+		repoName, crateDir := gitCfg.PackageRepo("rust", pkg)
+		srcDir := path.Join(cloneDir, repoName, crateDir, "src")
+		generatedPkgDir := path.Join(proto.OutDir, "rust", pkg)
+
+		// Replace sources with generated ones, so that files of deleted protos don't linger
+		if err := os.RemoveAll(srcDir); err != nil {
+			panic(err)
+		}
+		if err := util.CreateIfNotExists(srcDir, 0755); err != nil {
+			panic(err)
+		}
+		if err := util.CopyDirectory(generatedPkgDir, srcDir); err != nil {
+			panic(err)
+		}
+		writeLibRs(srcDir)
+
+		sources := readSources(srcDir, ".rs")
+		thirdPartyDeps := []Module{{Path: "prost", Version: rustCfg.Prost}}
+		if strings.Contains(sources, "prost_types::") {
+			thirdPartyDeps = append(thirdPartyDeps, Module{Path: "prost-types", Version: rustCfg.Prost})
+		}
+		if strings.Contains(sources, "tonic::") {
+			thirdPartyDeps = append(thirdPartyDeps, Module{Path: "tonic", Version: rustCfg.Tonic})
+		}
+
+		// Crates of other packages referenced by generated code are a fallback
+		deps := familyDeps(model, "rust", pkg, rustPackages, func() []string {
+			var deps []string
+			for _, match := range rustCrateRefRegexp.FindAllStringSubmatch(sources, -1) {
+				depPkg, ok := cratePackages[strings.ReplaceAll(match[1], "_", "-")]
+				if ok && depPkg != pkg && !containsString(deps, depPkg) {
+					deps = append(deps, depPkg)
+				}
+			}
+			return deps
+		})
+		var depCrates []string
+		for _, dep := range deps {
+			depCrates = append(depCrates, rustCrate(dep))
+		}
+
+		depResolver.AddModule(rustCrate(pkg), "", depCrates, thirdPartyDeps)
+	}
+
+	// Crates are committed in dependency order, so that dependents can reference commits of their dependencies
+	depResolver.Resolve()
+
+	if rustCfg.RegistryDir == "" {
+		return
+	}
+	for _, pkg := range rustPackages {
+		repoName, crateDir := gitCfg.PackageRepo("rust", pkg)
+		if dryRun {
+			fmt.Printf("dry run, crate %s won't be packaged into %s\n", rustCrate(pkg), rustCfg.RegistryDir)
+			continue
+		}
+		packageCrate(path.Join(cloneDir, repoName, crateDir), rustCfg.RegistryDir, rustCrate(pkg), releaseVersion(gitCfg, "rust", pkg), crateDeps[rustCrate(pkg)], cratePackages, gitCfg)
+	}
+}
+
+// rustCrate returns name of the crate of a proto package (e.g proto-foo-bar for foo_bar)
+func rustCrate(pkg string) string {
+	return "proto-" + strings.ReplaceAll(strings.ToLower(pkg), "_", "-")
+}
+
+// rustGitDependency returns Cargo.toml dependency line of a family crate in git, at a tag, commit or monorepo branch
+func rustGitDependency(gitCfg git.Config, pkg string, version string) string {
+	repoName, _ := gitCfg.PackageRepo("rust", pkg)
+	refType, _ := gitCfg.Release(repoName, "").ParseRef()
+
+	refKey := "rev"
+	if refType == git.TagRef {
+		refKey = "tag"
+	} else if gitCfg.Monorepo != "" {
+		refKey = "branch"
+	}
+
+	return fmt.Sprintf(`%s = { git = "%s", %s = "%s" }`, rustCrate(pkg), gitCfg.PackageURL(repoName), refKey, version)
+}
+
+func writeCargoToml(filename string, name string, version string, dependencies []string) {
+	type CargoTomlData struct {
+		Name         string
+		Version      string
+		Dependencies []string
+	}
+
+	tmpl, err := template.New("cargo").Parse(CargoTomlTemplate)
+	if err != nil {
+		panic(err)
+	}
+	buffer := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(buffer, CargoTomlData{Name: name, Version: version, Dependencies: dependencies}); err != nil {
+		panic(err)
+	}
+
+	if err := ioutil.WriteFile(filename, buffer.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+}
+
+// rustModule is a node of lib.rs module tree, with generated files included into it
+type rustModule struct {
+	Includes []string
+	Children map[string]*rustModule
+}
+
+// writeLibRs writes lib.rs which includes generated files into modules of their proto packages.
+// prost writes a file per proto package (foo.v1.rs), pbjson serde impls (foo.v1.serde.rs) go into the same module.
+func writeLibRs(srcDir string) {
+	entries, err := ioutil.ReadDir(srcDir)
+	if err != nil {
+		panic(err)
+	}
+
+	root := &rustModule{Children: make(map[string]*rustModule)}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".rs" || entry.Name() == "lib.rs" {
+			continue
+		}
+		protoPackage := strings.TrimSuffix(strings.TrimSuffix(entry.Name(), ".rs"), ".serde")
+
+		module := root
+		// Files of protos without a package are named "_.rs"
+		if protoPackage != "_" {
+			for _, name := range strings.Split(protoPackage, ".") {
+				if _, ok := module.Children[name]; !ok {
+					module.Children[name] = &rustModule{Children: make(map[string]*rustModule)}
+				}
+				module = module.Children[name]
+			}
+		}
+		module.Includes = append(module.Includes, entry.Name())
+	}
+
+	buffer := bytes.NewBufferString("// Code generated by protodist. DO NOT EDIT.\n")
+	writeRustModule(buffer, root, 0)
+	if err := ioutil.WriteFile(path.Join(srcDir, "lib.rs"), buffer.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+}
+
+func writeRustModule(buffer *bytes.Buffer, module *rustModule, depth int) {
+	indent := strings.Repeat("    ", depth)
+	for _, include := range module.Includes {
+		fmt.Fprintf(buffer, "%sinclude!(\"%s\");\n", indent, include)
+	}
+
+	var names []string
+	for name := range module.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(buffer, "%spub mod %s {\n", indent, name)
+		writeRustModule(buffer, module.Children[name], depth+1)
+		fmt.Fprintf(buffer, "%s}\n", indent)
+	}
+}
+
+// Index entry of a crate version in a cargo registry
+type crateIndexEntry struct {
+	Name     string              `json:"name"`
+	Vers     string              `json:"vers"`
+	Deps     []crateIndexDep     `json:"deps"`
+	Cksum    string              `json:"cksum"`
+	Features map[string][]string `json:"features"`
+	Yanked   bool                `json:"yanked"`
+	Links    *string             `json:"links"`
+}
+
+type crateIndexDep struct {
+	Name            string   `json:"name"`
+	Req             string   `json:"req"`
+	Features        []string `json:"features"`
+	Optional        bool     `json:"optional"`
+	DefaultFeatures bool     `json:"default_features"`
+	Target          *string  `json:"target"`
+	Kind            string   `json:"kind"`
+}
+
+// packageCrate packages a crate into a cargo local registry (https://doc.rust-lang.org/cargo/reference/source-replacement.html#local-registry-sources).
+// In the registry, family crates are required by exact version instead of git ref.
+func packageCrate(crateDir string, registryDir string, crate string, version string, deps []Module, cratePackages map[string]string, gitCfg git.Config) {
+	fmt.Println("packaging crate", crate, version)
+
+	var dependencies []string
+	var indexDeps []crateIndexDep
+	for _, dep := range deps {
+		req := dep.Version
+		if depPkg, ok := cratePackages[dep.Path]; ok {
+			req = "=" + releaseVersion(gitCfg, "rust", depPkg)
+		}
+		dependencies = append(dependencies, fmt.Sprintf(`%s = "%s"`, dep.Path, req))
+		indexDeps = append(indexDeps, crateIndexDep{Name: dep.Path, Req: req, Features: []string{}, DefaultFeatures: true, Kind: "normal"})
+	}
+
+	// Crate is a gzipped tarball of <crate>-<version> dir with Cargo.toml and sources
+	buildDir, err := ioutil.TempDir("", "protodist-crate-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(buildDir)
+	writeCargoToml(path.Join(buildDir, "Cargo.toml"), crate, version, dependencies)
+	if err := util.CreateIfNotExists(path.Join(buildDir, "src"), 0755); err != nil {
+		panic(err)
+	}
+	if err := util.CopyDirectory(path.Join(crateDir, "src"), path.Join(buildDir, "src")); err != nil {
+		panic(err)
+	}
+	data, err := tarGz(buildDir, fmt.Sprintf("%s-%s", crate, version), nil)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := os.MkdirAll(registryDir, 0755); err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(path.Join(registryDir, fmt.Sprintf("%s-%s.crate", crate, version)), data, 0644); err != nil {
+		panic(err)
+	}
+
+	checksum := sha256.Sum256(data)
+	entry := crateIndexEntry{
+		Name:     crate,
+		Vers:     version,
+		Deps:     indexDeps,
+		Cksum:    hex.EncodeToString(checksum[:]),
+		Features: map[string][]string{},
+	}
+	writeIndexEntry(path.Join(registryDir, "index", crateIndexPath(crate)), entry)
+}
+
+// crateIndexPath returns path of a crate file in registry index (e.g pr/ot/proto-foo)
+func crateIndexPath(crate string) string {
+	switch len(crate) {
+	case 1:
+		return path.Join("1", crate)
+	case 2:
+		return path.Join("2", crate)
+	case 3:
+		return path.Join("3", crate[:1], crate)
+	}
+
+	return path.Join(crate[:2], crate[2:4], crate)
+}
+
+// writeIndexEntry writes an entry into a crate index file, replacing an existing entry of the same version
+func writeIndexEntry(filename string, entry crateIndexEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		panic(err)
+	}
+
+	var lines []string
+	if existing, err := ioutil.ReadFile(filename); err == nil {
+		for _, existingLine := range strings.Split(strings.TrimSpace(string(existing)), "\n") {
+			var existingEntry crateIndexEntry
+			if json.Unmarshal([]byte(existingLine), &existingEntry) == nil && existingEntry.Vers == entry.Vers {
+				continue
+			}
+			lines = append(lines, existingLine)
+		}
+	}
+	lines = append(lines, string(line))
+
+	if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		panic(err)
+	}
+}
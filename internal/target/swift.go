@@ -0,0 +1,146 @@
+package target
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/4nte/protodist/config"
+	"github.com/4nte/protodist/git"
+	"github.com/4nte/protodist/internal/release"
+	"github.com/4nte/protodist/util"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const PackageSwiftTemplate = `// swift-tools-version:5.3
+// Code generated by protodist. DO NOT EDIT.
+import PackageDescription
+
+let package = Package(
+    name: "{{ .Name }}",
+    products: [
+{{- range .Targets }}
+        .library(name: "{{ .Name }}", targets: ["{{ .Name }}"]),
+{{- end }}
+    ],
+    dependencies: [
+        .package(url: "https://github.com/apple/swift-protobuf.git", from: "{{ .Protobuf }}"),
+    ],
+    targets: [
+{{- range .Targets }}
+        .target(
+            name: "{{ .Name }}",
+            dependencies: [
+                .product(name: "SwiftProtobuf", package: "swift-protobuf"),
+{{- range .Dependencies }}
+                "{{ . }}",
+{{- end }}
+            ],
+            path: "{{ .Path }}"
+        ),
+{{- end }}
+    ]
+)
+`
+
+// Modules imported by swift sources, swift-protobuf imports family modules when ProtoPathModuleMappings are set
+var swiftImportRegexp = regexp.MustCompile(`(?m)^import\s+([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Swift distributes all packages through a single swift package, with a library product per proto package.
+// Package.swift is in the repo root, so that SwiftPM can resolve it. In monorepo, sources reside in swift/<Target> dirs.
+func Swift(proto config.Proto, gitCfg git.Config, cloneBranch string, cloneDir string, dryRun bool, deployTarget string, deployDir string, swiftCfg config.Swift) {
+	model := loadModel(proto)
+	swiftPackages := discoverPackages(proto.OutDir, model, "swift")
+
+	repoName := "proto-all-swift"
+	sourcesDir := "Sources"
+	if gitCfg.Monorepo != "" {
+		repoName = gitCfg.Monorepo
+		sourcesDir = "swift"
+	} else {
+		git.CloneOrCreate(gitCfg, repoName, cloneBranch, dryRun)
+	}
+
+	// SwiftPM resolves packages only by semver tags, with or without "v" prefix
+	if refType, refName := gitCfg.Release(repoName, "").ParseRef(); refType == git.TagRef {
+		if _, ok := release.ParseVersion("v" + strings.TrimPrefix(refName, "v")); !ok {
+			fmt.Printf("warning: tag %s is not a semantic version, SwiftPM won't resolve %s by it\n", refName, repoName)
+		}
+	}
+
+	// Replace sources with generated ones, so that files of deleted protos and packages don't linger
+	if err := os.RemoveAll(path.Join(cloneDir, repoName, sourcesDir)); err != nil {
+		panic(err)
+	}
+
+	type SwiftTarget struct {
+		Name         string
+		Path         string
+		Dependencies []string
+	}
+	var targets []SwiftTarget
+	targetPackages := make(map[string]string)
+	for _, pkg := range swiftPackages {
+		targetPackages[swiftTarget(pkg)] = pkg
+	}
+
+	for _, pkg := range swiftPackages {
+		targetPath := path.Join(sourcesDir, swiftTarget(pkg))
+		targetDir := path.Join(cloneDir, repoName, targetPath)
+		if err := util.CreateIfNotExists(targetDir, 0755); err != nil {
+			panic(err)
+		}
+		if err := util.CopyDirectory(path.Join(proto.OutDir, "swift", pkg), targetDir); err != nil {
+			panic(err)
+		}
+
+		// Imports of targets of other packages are a fallback
+		deps := familyDeps(model, "swift", pkg, swiftPackages, func() []string {
+			var deps []string
+			for _, match := range swiftImportRegexp.FindAllStringSubmatch(readSources(targetDir, ".swift"), -1) {
+				depPkg, ok := targetPackages[match[1]]
+				if ok && depPkg != pkg && !containsString(deps, depPkg) {
+					deps = append(deps, depPkg)
+				}
+			}
+			return deps
+		})
+		var depTargets []string
+		for _, dep := range deps {
+			depTargets = append(depTargets, swiftTarget(dep))
+		}
+
+		targets = append(targets, SwiftTarget{Name: swiftTarget(pkg), Path: targetPath, Dependencies: depTargets})
+	}
+
+	data := struct {
+		Name     string
+		Protobuf string
+		Targets  []SwiftTarget
+	}{Name: repoName, Protobuf: swiftCfg.Protobuf, Targets: targets}
+
+	tmpl, err := template.New("package").Parse(PackageSwiftTemplate)
+	if err != nil {
+		panic(err)
+	}
+	buffer := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(buffer, data); err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(path.Join(cloneDir, repoName, "Package.swift"), buffer.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+
+	// Add to GIT, monorepo is published once all targets are done
+	if gitCfg.Monorepo == "" {
+		AddCommitTagPublish(gitCfg.Release(repoName, ""), repoName, swiftPackages, dryRun)
+	}
+}
+
+// swiftTarget returns name of the swift target and library product of a proto package (e.g ProtoFooBar for foo_bar)
+func swiftTarget(pkg string) string {
+	return "Proto" + pascalCase(pkg)
+}
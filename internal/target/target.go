@@ -1,25 +1,173 @@
 package target
 
 import (
+	"fmt"
+	"github.com/4nte/protodist/config"
 	"github.com/4nte/protodist/git"
+	"github.com/4nte/protodist/internal/descriptor"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
 )
 
-func AddCommitTagPush(cfg git.Config, repos []string, dryRun bool) {
-	for _, repo := range repos {
-		git.AddAll(repo)
-		git.Commit(repo, "add pb files")
+// loadModel loads the model of distributed packages if descriptor set is configured, nil is returned otherwise
+func loadModel(proto config.Proto) *descriptor.Model {
+	if proto.DescriptorSet == "" {
+		return nil
+	}
 
-		refType, refName := cfg.ParseRef()
+	model, err := descriptor.LoadModel(proto.DescriptorSet, proto.OutDir)
+	if err != nil {
+		panic(err)
+	}
 
-		if refType == git.TagRef {
-			// Create a git tag
-			git.Tag(repo, refName)
+	return model
+}
+
+// discoverPackages returns packages generated into a language dir of proto out dir (e.g go/foo).
+// Packages are taken from the model when there is one, otherwise every directory is a package.
+func discoverPackages(protoOutDir string, model *descriptor.Model, lang string) []string {
+	var packages []string
+	if model != nil {
+		for _, pkg := range model.PackageNames() {
+			info, err := os.Stat(path.Join(protoOutDir, lang, pkg))
+			if err != nil || !info.IsDir() {
+				fmt.Printf("warning: package %s has no %s output\n", pkg, lang)
+				continue
+			}
+			packages = append(packages, pkg)
 		}
-		if dryRun {
-			// Skip git push
-			return
+
+		return packages
+	}
+
+	entries, err := ioutil.ReadDir(path.Join(protoOutDir, lang))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			fmt.Printf("warning: skipping %s, only package directories are expected in %s output\n", entry.Name(), lang)
+			continue
+		}
+		packages = append(packages, entry.Name())
+	}
+
+	return packages
+}
+
+// familyDeps returns distributed packages which a package depends on. Dependencies are known precisely from descriptor
+// set, scan is a fallback (e.g. imports of generated code), which is called only when there is no model.
+// Dependency which has no output of the language fails the distribution, since generated code wouldn't build without it.
+func familyDeps(model *descriptor.Model, lang string, pkg string, langPackages []string, scan func() []string) []string {
+	if model == nil {
+		return scan()
+	}
+
+	for _, dep := range model.Packages[pkg].Dependencies {
+		if !containsString(langPackages, dep) {
+			panic(fmt.Sprintf("package %s depends on %s, which has no %s output", pkg, dep, lang))
 		}
+	}
 
-		git.Push(repo, refName)
+	return model.Packages[pkg].Dependencies
+}
+
+// releaseVersion returns version of a released package without "v" prefix, branches are versioned as 0.0.0.
+// Packages in monorepo are released along with the monorepo (only Go modules have their own tags).
+func releaseVersion(gitCfg git.Config, lang string, pkg string) string {
+	repoName, _ := gitCfg.PackageRepo(lang, pkg)
+	return repoVersion(gitCfg, repoName)
+}
+
+// repoVersion returns release version of a repo without "v" prefix, branches are versioned as 0.0.0
+func repoVersion(gitCfg git.Config, repoName string) string {
+	refType, refName := gitCfg.Release(repoName, "").ParseRef()
+	if refType != git.TagRef {
+		return "0.0.0"
 	}
+
+	return strings.TrimPrefix(refName, "v")
+}
+
+// readSources returns concatenated contents of files with given extensions in a dir
+func readSources(dir string, extensions ...string) string {
+	var sources strings.Builder
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !containsString(extensions, filepath.Ext(filePath)) {
+			return err
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		sources.Write(data)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return sources.String()
+}
+
+// hasFileWithSuffix reports whether there is a file with name suffix in a dir
+func hasFileWithSuffix(dir string, suffix string) bool {
+	var found bool
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(info.Name(), suffix) {
+			found = true
+		}
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return found
+}
+
+// pascalCase converts a package name to PascalCase (e.g FooBar for foo_bar)
+func pascalCase(pkg string) string {
+	var name string
+	for _, part := range strings.FieldsFunc(pkg, func(r rune) bool { return r == '_' || r == '-' || r == '.' }) {
+		name += strings.ToUpper(part[:1]) + part[1:]
+	}
+
+	return name
+}
+
+func containsString(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddCommitTagPublish commits all changes in a cloned repo, tags the commit if ref is a tag, and publishes the repo.
+// packages are proto packages distributed through the repo.
+func AddCommitTagPublish(cfg git.Config, repo string, packages []string, dryRun bool) {
+	git.AddAll(repo)
+	if git.HasStagedChanges(repo) {
+		git.Commit(repo, "add pb files")
+	}
+
+	refType, refName := cfg.ParseRef()
+
+	if refType == git.TagRef {
+		// Create a git tag
+		git.Tag(repo, refName)
+	}
+	if dryRun {
+		// Skip git push
+		return
+	}
+
+	git.Publish(cfg, repo, packages)
 }
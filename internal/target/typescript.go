@@ -0,0 +1,221 @@
+package target
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/4nte/protodist/util"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Relative module specifiers of import and export statements, and of dynamic imports (e.g from "./foo_pb")
+var jsRelativeSpecifierRegexp = regexp.MustCompile(`((?:\bfrom|\bimport)\s*\(?\s*['"])(\.\.?(?:/[^'"]*)?)(['"])`)
+
+// TsconfigJSON is tsconfig.json of a compiled npm package. It emits CommonJS modules,
+// ES modules and declarations are emitted by overriding module and outDir.
+const TsconfigJSON = `{
+  "compilerOptions": {
+    "target": "es2017",
+    "module": "commonjs",
+    "moduleResolution": "node",
+    "esModuleInterop": true,
+    "skipLibCheck": true,
+    "outDir": "dist/cjs",
+    "rootDir": "."
+  },
+  "include": ["**/*.ts"],
+  "exclude": ["node_modules", "dist"]
+}
+`
+
+// typeScriptCompiler returns compiler of typescript sources, tsc is preferred as it type checks sources and emits declarations
+func typeScriptCompiler() string {
+	if _, err := exec.LookPath("tsc"); err == nil {
+		return "tsc"
+	}
+	if _, err := exec.LookPath("esbuild"); err == nil {
+		fmt.Println("warning: tsc not found, typescript is compiled with esbuild, which doesn't type check sources or emit declarations")
+		return "esbuild"
+	}
+
+	panic("compiling typescript requires tsc or esbuild in PATH")
+}
+
+// compileTypeScript compiles typescript sources of an npm package into dist/esm and dist/cjs, along with declarations
+// in dist/types when compiled with tsc. Type errors fail the build. Runtime dependencies are installed with npm,
+// family packages are linked from familyPackageDirs (package name -> package dir), they must be compiled already.
+func compileTypeScript(compiler string, packageDir string, packageJSON PackageJSON, familyPackageDirs map[string]string) {
+	fmt.Println("compiling typescript of", packageJSON.Name)
+	distDir := path.Join(packageDir, "dist")
+	if err := os.RemoveAll(distDir); err != nil {
+		panic(err)
+	}
+	if hasFileWithSuffix(packageDir, ".js") {
+		fmt.Printf("warning: %s contains javascript modules, only typescript modules are compiled into dist\n", packageJSON.Name)
+	}
+	if err := ioutil.WriteFile(path.Join(packageDir, "tsconfig.json"), []byte(TsconfigJSON), 0644); err != nil {
+		panic(err)
+	}
+
+	// Build in a copy of the package, so that node_modules don't end up in it
+	buildDir, err := ioutil.TempDir("", "protodist-tsc-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(buildDir)
+	if err := util.CopyDirectory(packageDir, buildDir); err != nil {
+		panic(err)
+	}
+	installNodeModules(buildDir, packageJSON.Dependencies, familyPackageDirs)
+
+	var commands [][]string
+	switch compiler {
+	case "tsc":
+		commands = [][]string{
+			{"tsc", "--project", "tsconfig.json"},
+			{"tsc", "--project", "tsconfig.json", "--module", "es2015", "--outDir", "dist/esm", "--declaration", "--declarationDir", "dist/types"},
+		}
+	case "esbuild":
+		sources := typeScriptSources(buildDir)
+		commands = [][]string{
+			append([]string{"esbuild", "--format=esm", "--target=es2017", "--outbase=.", "--outdir=dist/esm"}, sources...),
+			append([]string{"esbuild", "--format=cjs", "--target=es2017", "--outbase=.", "--outdir=dist/cjs"}, sources...),
+		}
+	}
+	for _, args := range commands {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Dir = buildDir
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			panic(fmt.Errorf("failed to compile typescript of %s: %s", packageJSON.Name, err))
+		}
+	}
+
+	// Node resolves relative imports of ES modules only by their full path
+	addESModuleExtensions(path.Join(buildDir, "dist", "esm"))
+
+	// Package.json doesn't declare a module type, so ES modules are marked by a nested package.json
+	for dir, moduleType := range map[string]string{"esm": "module", "cjs": "commonjs"} {
+		data := []byte(fmt.Sprintf("{\n  \"type\": \"%s\"\n}\n", moduleType))
+		if err := ioutil.WriteFile(path.Join(buildDir, "dist", dir, "package.json"), data, 0644); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := util.CreateIfNotExists(distDir, 0755); err != nil {
+		panic(err)
+	}
+	if err := util.CopyDirectory(path.Join(buildDir, "dist"), distDir); err != nil {
+		panic(err)
+	}
+}
+
+// installNodeModules installs runtime dependencies into node_modules of a build dir, family packages are symlinked
+func installNodeModules(buildDir string, dependencies map[string]string, familyPackageDirs map[string]string) {
+	thirdPartyDeps := make(map[string]string)
+	for name, version := range dependencies {
+		if _, ok := familyPackageDirs[name]; !ok {
+			thirdPartyDeps[name] = version
+		}
+	}
+
+	if len(thirdPartyDeps) > 0 {
+		// Package.json of the build dir lists only dependencies installed from the registry
+		data, err := json.MarshalIndent(map[string]interface{}{"private": true, "dependencies": thirdPartyDeps}, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		if err := ioutil.WriteFile(path.Join(buildDir, "package.json"), data, 0644); err != nil {
+			panic(err)
+		}
+
+		cmd := exec.Command("npm", "install", "--ignore-scripts", "--no-audit", "--no-fund", "--no-package-lock")
+		cmd.Dir = buildDir
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			panic(fmt.Errorf("failed to install dependencies of %s: %s", buildDir, err))
+		}
+	}
+
+	for name, dir := range familyPackageDirs {
+		linkPath := path.Join(buildDir, "node_modules", name)
+		if err := os.MkdirAll(path.Dir(linkPath), 0755); err != nil {
+			panic(err)
+		}
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			panic(err)
+		}
+		if err := os.Symlink(absDir, linkPath); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// addESModuleExtensions adds extensions to relative specifiers of ES modules in a dir, which typescript emits as they
+// are written in sources (e.g "./foo_pb" into "./foo_pb.js", "./foo" into "./foo/index.js" when foo is a directory).
+// Specifiers which don't resolve to a module of the dir are left as they are.
+func addESModuleExtensions(dir string) {
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(filePath) != ".js" {
+			return err
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		rewritten := jsRelativeSpecifierRegexp.ReplaceAllStringFunc(string(data), func(statement string) string {
+			match := jsRelativeSpecifierRegexp.FindStringSubmatch(statement)
+			specifier := match[2]
+			switch path.Ext(specifier) {
+			case ".js", ".mjs", ".cjs", ".json":
+				return statement
+			}
+			target := path.Join(path.Dir(filePath), specifier)
+			if info, err := os.Stat(target + ".js"); err == nil && !info.IsDir() {
+				return match[1] + specifier + ".js" + match[3]
+			}
+			if info, err := os.Stat(path.Join(target, "index.js")); err == nil && !info.IsDir() {
+				return match[1] + strings.TrimSuffix(specifier, "/") + "/index.js" + match[3]
+			}
+			return statement
+		})
+
+		return ioutil.WriteFile(filePath, []byte(rewritten), info.Mode())
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// typeScriptSources returns typescript modules of a dir, relative to it
+func typeScriptSources(dir string) []string {
+	var sources []string
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() && (info.Name() == "node_modules" || info.Name() == "dist" || info.Name() == ".git") {
+			return filepath.SkipDir
+		}
+		if err != nil || info.IsDir() || !strings.HasSuffix(filePath, ".ts") || strings.HasSuffix(filePath, ".d.ts") {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, relPath)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return sources
+}
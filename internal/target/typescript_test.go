@@ -0,0 +1,62 @@
+package target
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestAddESModuleExtensions(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.js": `export * as a from "./a";
+export * from './b/b_pb';
+import { C } from "./c.js";
+import Long from "long";
+import "./missing";
+const lazy = import("./a/a_pb");
+`,
+		"a/index.js": `export * from "./a_pb";
+`,
+		"a/a_pb.js": `import { B } from "../b/b_pb";
+`,
+		"b/b_pb.js": `export const B = 1;
+`,
+		"c.js": `export const C = 1;
+`,
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(path.Dir(path.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addESModuleExtensions(dir)
+
+	want := map[string]string{
+		"index.js": `export * as a from "./a/index.js";
+export * from './b/b_pb.js';
+import { C } from "./c.js";
+import Long from "long";
+import "./missing";
+const lazy = import("./a/a_pb.js");
+`,
+		"a/index.js": `export * from "./a_pb.js";
+`,
+		"a/a_pb.js": `import { B } from "../b/b_pb.js";
+`,
+	}
+	for name, content := range want {
+		data, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s =\n%s\nwant\n%s", name, data, content)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 )
 
@@ -109,3 +110,78 @@ func CopySymLink(source, dest string) error {
 	}
 	return os.Symlink(link, dest)
 }
+
+// DefaultProtected are patterns of hand-maintained files, which generated code never has: dotfiles (e.g .gitignore,
+// .gitattributes, .github), readme, license, changelog and CODEOWNERS files
+var DefaultProtected = []string{".*", "README*", "LICENSE*", "LICENCE*", "CHANGELOG*", "CODEOWNERS"}
+
+// SyncDirectory makes dest an exact copy of srcDir. Files of srcDir are copied to dest, files of dest which aren't in
+// srcDir are removed, along with dirs left empty. Paths which match a protected pattern (e.g README*, docs/*.md), and
+// .git, are left intact. Patterns are matched against paths relative to dest and to each dir below it, so README*
+// protects README.md of dest and of every package dir within dest.
+func SyncDirectory(srcDir, dest string, protected []string) error {
+	if err := CreateIfNotExists(dest, 0755); err != nil {
+		return err
+	}
+
+	var dirs []string
+	err := filepath.Walk(dest, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dest, filePath)
+		if err != nil || relPath == "." {
+			return err
+		}
+		if info.Name() == ".git" || isProtected(filepath.ToSlash(relPath), protected) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			dirs = append(dirs, filePath)
+			return nil
+		}
+
+		if srcInfo, err := os.Lstat(filepath.Join(srcDir, relPath)); err == nil && !srcInfo.IsDir() {
+			return nil
+		}
+		return os.Remove(filePath)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Dirs are walked in lexical order, so subdirs are removed before their parents
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entries, err := ioutil.ReadDir(dirs[i])
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			if err := os.Remove(dirs[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return CopyDirectory(srcDir, dest)
+}
+
+func isProtected(relPath string, protected []string) bool {
+	for {
+		for _, pattern := range protected {
+			if matched, _ := filepath.Match(pattern, relPath); matched {
+				return true
+			}
+		}
+
+		// Path relative to the next dir below
+		i := strings.Index(relPath, "/")
+		if i < 0 {
+			return false
+		}
+		relPath = relPath[i+1:]
+	}
+}
@@ -0,0 +1,201 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSyncDirectory(t *testing.T) {
+	srcDir := t.TempDir()
+	writeFiles(t, srcDir, map[string]string{
+		"foo/foo_pb.ts":     "generated foo",
+		"foo/sub/sub_pb.ts": "generated sub",
+		"bar/bar_pb.ts":     "generated bar",
+	})
+
+	dest := t.TempDir()
+	writeFiles(t, dest, map[string]string{
+		"foo/foo_pb.ts":         "stale foo",
+		"foo/deleted_pb.ts":     "stale",
+		"foo/README.md":         "foo readme",
+		"foo/docs/usage.md":     "foo usage",
+		"foo/docs/deleted.txt":  "stale",
+		"baz/baz_pb.ts":         "stale",
+		"baz/nested/nested.ts":  "stale",
+		"README.md":             "readme",
+		".github/workflows/ci":  "ci",
+		".git/HEAD":             "ref: refs/heads/master",
+		".git/objects/info/foo": "object",
+	})
+	if err := os.MkdirAll(filepath.Join(dest, "empty/dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SyncDirectory(srcDir, dest, []string{"README*", "docs/*.md", ".github"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	var dirs []string
+	err := filepath.Walk(dest, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dest, filePath)
+		if err != nil || relPath == "." {
+			return err
+		}
+		if info.IsDir() {
+			dirs = append(dirs, filepath.ToSlash(relPath))
+			return nil
+		}
+		data, err := ioutil.ReadFile(filePath)
+		got[filepath.ToSlash(relPath)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"foo/foo_pb.ts":         "generated foo",
+		"foo/sub/sub_pb.ts":     "generated sub",
+		"bar/bar_pb.ts":         "generated bar",
+		"foo/README.md":         "foo readme",
+		"foo/docs/usage.md":     "foo usage",
+		"README.md":             "readme",
+		".github/workflows/ci":  "ci",
+		".git/HEAD":             "ref: refs/heads/master",
+		".git/objects/info/foo": "object",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("synced files %v, want %v", got, want)
+	}
+	wantDirs := []string{".git", ".git/objects", ".git/objects/info", ".github", ".github/workflows", "bar", "foo", "foo/docs", "foo/sub"}
+	if !reflect.DeepEqual(dirs, wantDirs) {
+		t.Errorf("synced dirs %v, want %v", dirs, wantDirs)
+	}
+}
+
+func TestIsProtected(t *testing.T) {
+	protected := []string{"README*", "docs/*.md", ".github"}
+	tests := []struct {
+		relPath string
+		want    bool
+	}{
+		{"README.md", true},
+		{"foo/README.md", true},
+		{"foo/bar/README", true},
+		{"docs/usage.md", true},
+		{"foo/docs/usage.md", true},
+		{"foo/docs/usage.txt", false},
+		{"foo/docs", false},
+		{".github", true},
+		{"foo/.github", true},
+		{"foo/foo_pb.ts", false},
+		{"READ/foo.ts", false},
+	}
+
+	for _, test := range tests {
+		if got := isProtected(test.relPath, protected); got != test.want {
+			t.Errorf("isProtected(%s) = %t, want %t", test.relPath, got, test.want)
+		}
+	}
+}
+
+func TestSyncDirectoryDefaultProtected(t *testing.T) {
+	tests := []struct {
+		name      string
+		protected []string
+		generated map[string]string
+		repo      map[string]string
+		want      []string
+	}{
+		{
+			name:      "javascript package",
+			protected: append([]string{"package.override.json"}, DefaultProtected...),
+			generated: map[string]string{"foo_pb.ts": "generated"},
+			repo: map[string]string{
+				"foo_pb.ts":             "stale",
+				"deleted_pb.ts":         "stale",
+				".gitignore":            "node_modules",
+				".gitattributes":        "* text=auto",
+				".editorconfig":         "root = true",
+				".npmrc":                "access=public",
+				".npmignore":            "*.ts",
+				".github/CODEOWNERS":    "* @org/team",
+				"CODEOWNERS":            "* @org/team",
+				"LICENSE":               "MIT",
+				"README.md":             "readme",
+				"package.override.json": "{}",
+			},
+			want: []string{
+				".editorconfig", ".gitattributes", ".github/CODEOWNERS", ".gitignore", ".npmignore", ".npmrc",
+				"CODEOWNERS", "LICENSE", "README.md", "foo_pb.ts", "package.override.json",
+			},
+		},
+		{
+			name:      "C package",
+			protected: DefaultProtected,
+			generated: map[string]string{"foo.pb.c": "generated", "proto/foo/foo.pb.h": "generated"},
+			repo: map[string]string{
+				"foo.pb.c":             "stale",
+				"deleted.pb.c":         "stale",
+				"proto/foo/foo.pb.h":   "stale",
+				"proto/foo/deleted.h":  "stale",
+				".gitignore":           "build/",
+				".gitattributes":       "*.pb.* linguist-generated",
+				".clang-format":        "BasedOnStyle: LLVM",
+				".github/workflows/ci": "ci",
+				"CODEOWNERS":           "* @org/firmware",
+				"LICENCE.txt":          "BSD",
+				"CHANGELOG.md":         "changelog",
+			},
+			want: []string{
+				".clang-format", ".gitattributes", ".github/workflows/ci", ".gitignore",
+				"CHANGELOG.md", "CODEOWNERS", "LICENCE.txt", "foo.pb.c", "proto/foo/foo.pb.h",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		srcDir := t.TempDir()
+		writeFiles(t, srcDir, test.generated)
+		dest := t.TempDir()
+		writeFiles(t, dest, test.repo)
+
+		if err := SyncDirectory(srcDir, dest, test.protected); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		err := filepath.Walk(dest, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			relPath, err := filepath.Rel(dest, filePath)
+			got = append(got, filepath.ToSlash(relPath))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: synced files %v, want %v", test.name, got, test.want)
+		}
+	}
+}